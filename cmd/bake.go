@@ -1,19 +1,39 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"mtxconv/mtx"
 )
 
 var (
-	mtxTargetVersion int
-	jpegQuality      int
-)
+	mtxTargetVersion    int
+	jpegQuality         int
+	reencodeJPEGEnabled bool
+	bakeInfoEnabled     bool
+	bleedStrategy       string
+	linearLightEnabled  bool
+	resampleFilter      string
+	sharpenSigma        float64
+	oddSizePolicy       string
+	smallImagePath      string
+	smallJPEGQuality    int
+	tierCount           int
 
-const (
-	defaultJPEGQuality = 90 // estimated from extracted JPEG files
+	pvrFormat             string
+	pvrtcIterativeEnabled bool
+	padPVRTCEnabled       bool
+	twiddlePVREnabled     bool
+	v2Unknown             int
 )
 
 // bakeCmd represents the tomtx command
@@ -28,9 +48,19 @@ var bakeCmd = &cobra.Command{
 
 		log.Debugf("bake called: %d", mtxTargetVersion)
 
+		if smallImagePath != "" && len(args) > 1 {
+			log.Error("--small can only be used when baking a single file")
+			return
+		}
+
 		for _, file := range args {
+			if large := largeImageFor(file); large != "" && containsFile(args, large) {
+				log.Infof("Skipping %s, which is used as the smaller image of %s", file, large)
+				continue
+			}
+
 			log.Info(file)
-			if err := mtx.CreateMTXFile(file, mtxTargetVersion, jpegQuality, dryRunEnabled); err != nil {
+			if err := bakeFile(file, mtxTargetVersion); err != nil {
 				log.Error(err)
 			}
 			fmt.Println()
@@ -40,6 +70,315 @@ var bakeCmd = &cobra.Command{
 
 func init() {
 	bakeCmd.Flags().IntVarP(&mtxTargetVersion, "mtx-version", "m", -1, "Target MTX version. Needs to be one of 0, 1, 2, or -1 to autoselect (Default -1)")
-	bakeCmd.Flags().IntVarP(&jpegQuality, "jpeg-quality", "q", defaultJPEGQuality, fmt.Sprintf("JPEG quality (Default %d)", defaultJPEGQuality))
+	bakeCmd.Flags().IntVarP(&jpegQuality, "jpeg-quality", "q", mtx.DefaultJPEGQuality, fmt.Sprintf("JPEG quality (Default %d)", mtx.DefaultJPEGQuality))
+	bakeCmd.Flags().BoolVarP(&reencodeJPEGEnabled, "reencode-jpeg", "", false, "re-encode JPEG input files instead of embedding them as they are")
+	bakeCmd.Flags().BoolVarP(&bakeInfoEnabled, "info", "", false, "print the headers and layout of the baked files")
+	bakeCmd.Flags().StringVarP(&bleedStrategy, "bleed", "", string(mtx.DefaultBleed), fmt.Sprintf("how to fill the colors of invisible pixels in MTXv1 files. One of: %s", joinBleedStrategies()))
+	bakeCmd.Flags().BoolVarP(&linearLightEnabled, "linear", "", false, "resample the smaller image in linear light instead of sRGB")
+	bakeCmd.Flags().StringVarP(&resampleFilter, "filter", "", mtx.DefaultFilter, fmt.Sprintf("filter used to scale down the smaller image. One of: %s", joinResampleFilters()))
+	bakeCmd.Flags().Float64VarP(&sharpenSigma, "sharpen", "", 0, "sharpen the smaller image with an unsharp mask of this strength (sigma) after scaling it down. 0 disables it")
+	bakeCmd.Flags().StringVarP(&oddSizePolicy, "odd-size", "", string(mtx.DefaultOddSize), fmt.Sprintf("how to size the smaller image if the image's sides are odd. One of: %s", joinOddSizePolicies()))
+	bakeCmd.Flags().IntVarP(&tierCount, "tiers", "", mtx.DefaultTierCount, "number of images in MTXv0 and MTXv1 files, each one half the size of the next. 1 only stores the input image, more than 2 is experimental")
+	bakeCmd.Flags().StringVarP(&smallImagePath, "small", "", "", "use this image as the smaller image instead of scaling down the input file. Defaults to foo@1x.png when baking foo@2x.png, if it exists")
+	bakeCmd.Flags().IntVarP(&smallJPEGQuality, "small-quality", "", 0, "JPEG quality of the smaller image. Defaults to the JPEG quality of the larger one")
+	bakeCmd.Flags().StringVarP(&pvrFormat, "pvr-format", "", string(mtx.DefaultPVRFormat), fmt.Sprintf("pixel format of MTXv2 textures created from images. One of: %s. The games use PVRTC-II (pvrtcii4); whether they load PVRTC1 (pvrtc4, pvrtc2) is unverified", joinPVRFormats()))
+	bakeCmd.Flags().BoolVarP(&pvrtcIterativeEnabled, "pvrtc-iterative", "", false, "use the slower, higher quality PVRTC compression mode")
+	bakeCmd.Flags().BoolVarP(&padPVRTCEnabled, "pad", "", false, "pad images to a square with power-of-two sides, as PVRTC1 requires, instead of rejecting them")
+	bakeCmd.Flags().BoolVarP(&twiddlePVREnabled, "twiddle", "", false, "store uncompressed MTXv2 textures in twiddled order, which needs power-of-two sides")
+	bakeCmd.Flags().IntVarP(&v2Unknown, "v2-unknown", "", mtx.DEFAULT_V2_UNKNOWN, "value of the unknown field in the header of MTXv2 files")
 	rootCmd.AddCommand(bakeCmd)
 }
+
+// selectTargetVersion checks whether the input file's type can be baked into the requested MTX version.
+// A target version of -1 selects the appropriate version for the file type
+func selectTargetVersion(fileExt string, mtxTargetVersion int) (int, error) {
+	if mtxTargetVersion < -1 || mtxTargetVersion > 2 {
+		return 0, fmt.Errorf("an MTX target version of %d is unsupported. Supported values are: -1, 0, 1, and 2", mtxTargetVersion)
+	}
+
+	switch fileExt {
+	case "mtx":
+		return 0, errors.New("already an MTX file")
+	case "jpeg", "jpg":
+		if mtxTargetVersion == -1 {
+			mtxTargetVersion = 0
+		}
+	case "png":
+		if mtxTargetVersion == -1 {
+			mtxTargetVersion = 1
+		}
+	case "pvr", "ktx", "ktx2", "dds":
+		if mtxTargetVersion == -1 {
+			mtxTargetVersion = 2
+		}
+		if mtxTargetVersion != 2 {
+			return 0, fmt.Errorf("%s files are only supported with MTX target version 2", strings.ToUpper(fileExt))
+		}
+	default:
+		return 0, errors.New("unsupported file format")
+	}
+
+	return mtxTargetVersion, nil
+}
+
+func bakeFile(file string, mtxTargetVersion int) error {
+	fileDir, fileBase := filepath.Split(file)
+	fileNameSplit := strings.Split(fileBase, ".")
+	fileExt := strings.ToLower(fileNameSplit[len(fileNameSplit)-1])
+	newOutFilePath := filepath.Join(fileDir, fmt.Sprintf("%s.mtx", fileBase))
+
+	// Do preflight checks here so they won't have to be repeated later
+	targetVersion, err := selectTargetVersion(fileExt, mtxTargetVersion)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Selected MTX format: %d", targetVersion)
+
+	if targetVersion == 2 && smallImagePath != "" {
+		return errors.New("MTXv2 files don't contain a smaller image, so --small can't be used with them")
+	} else if targetVersion == 2 && (v2Unknown < 0 || v2Unknown > math.MaxUint16) {
+		return fmt.Errorf("a value of %d for the unknown MTXv2 header field is unsupported. It needs to be between 0 and %d", v2Unknown, math.MaxUint16)
+	}
+
+	if targetVersion == 1 {
+		if err := checkBleedStrategy(bleedStrategy); err != nil {
+			return err
+		}
+	}
+	if targetVersion != 2 {
+		if err := checkTierOptions(); err != nil {
+			return err
+		}
+	}
+
+	f, err := openInputFile(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts := &mtx.BakeOptions{
+		JPEGQuality:      jpegQuality,
+		SmallJPEGQuality: smallJPEGQuality,
+		Tiers:            tierCount,
+		Bleed:            mtx.BleedStrategy(bleedStrategy),
+		LinearLight:      linearLightEnabled,
+		Filter:           resampleFilter,
+		Sharpen:          sharpenSigma,
+		OddSize:          mtx.OddSizePolicy(oddSizePolicy),
+		PVRFormat:        mtx.PVRFormat(pvrFormat),
+		PVRTCIterative:   pvrtcIterativeEnabled,
+		PadPVRTC:         padPVRTCEnabled,
+		TwiddlePVR:       twiddlePVREnabled,
+		ReencodeJPEG:     reencodeJPEGEnabled,
+		Limits:           &resourceLimits,
+		DryRun:           dryRunEnabled,
+		OutputPath:       newOutFilePath,
+	}
+
+	// by this point, only valid input files for any given MTX target versions should remain
+	var mtxFile *mtx.File
+	if fileExt == "pvr" || fileExt == "ktx" || fileExt == "ktx2" || fileExt == "dds" {
+		// textures in other containers are rewrapped as legacy PVR textures without decoding them
+		pvrHeader, pvrData, err := mtx.DecodePVRWithLimits(f, &resourceLimits)
+		if err != nil {
+			return err
+		}
+
+		mtxFile = mtx.NewPVRFile(pvrHeader, pvrData)
+	} else if (fileExt == "jpeg" || fileExt == "jpg") && targetVersion != 2 {
+		jpegData, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		mtxFile, err = mtx.NewFileFromJPEG(uint32(targetVersion), jpegData, opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		if targetVersion == 2 {
+			if err := checkPVRFormat(pvrFormat); err != nil {
+				return err
+			} else if pvrFormat == string(mtx.PVRFormatPVRTC4) || pvrFormat == string(mtx.PVRFormatPVRTC2) {
+				log.Warnf("The games' own MTXv2 files use PVRTC-II, and it hasn't been verified that they load %s textures", pvrFormat)
+			}
+		}
+
+		img, err := decodeImage(f)
+		if err != nil {
+			return err
+		}
+
+		mtxFile, err = mtx.NewFile(uint32(targetVersion), img, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	if targetVersion == 2 {
+		if v2Unknown != mtx.DEFAULT_V2_UNKNOWN {
+			log.Warnf("Writing %d to the MTXv2 header's unknown field instead of the usual %d", v2Unknown, mtx.DEFAULT_V2_UNKNOWN)
+		}
+		mtxFile.HeaderV2.Unknown = uint16(v2Unknown)
+	} else if err := setSmallImage(mtxFile, file); err != nil {
+		return err
+	}
+
+	mtxData, err := mtx.WriteFile(mtxFile, opts)
+	if err != nil {
+		return err
+	}
+
+	if bakeInfoEnabled {
+		// decode the baked file so the info reflects what was actually written
+		bakedFile, err := mtx.DecodeWithLimits(bytes.NewReader(mtxData), &resourceLimits)
+		if err != nil {
+			return err
+		}
+
+		for i, tier := range bakedFile.Tiers {
+			tier.PassedThrough = mtxFile.Tiers[i].PassedThrough
+		}
+		bakedFile.OddSize = mtxFile.OddSize
+
+		printInfo(newOutFilePath, bakedFile.Info())
+	}
+
+	return nil
+}
+
+// checkPVRFormat makes sure format names one of the supported PVR formats
+func checkPVRFormat(format string) error {
+	for _, f := range mtx.PVRFormats() {
+		if string(f) == format {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported PVR format %q. Supported formats are: %s", format, joinPVRFormats())
+}
+
+func joinPVRFormats() string {
+	names := make([]string, len(mtx.PVRFormats()))
+	for i, f := range mtx.PVRFormats() {
+		names[i] = string(f)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// checkBleedStrategy makes sure strategy names one of the supported bleed strategies
+func checkBleedStrategy(strategy string) error {
+	for _, s := range mtx.BleedStrategies() {
+		if string(s) == strategy {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported bleed strategy %q. Supported strategies are: %s", strategy, joinBleedStrategies())
+}
+
+func joinBleedStrategies() string {
+	names := make([]string, len(mtx.BleedStrategies()))
+	for i, s := range mtx.BleedStrategies() {
+		names[i] = string(s)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// checkTierOptions makes sure the options used to generate smaller tiers are supported
+func checkTierOptions() error {
+	if tierCount < 1 {
+		return fmt.Errorf("a tier count of %d is unsupported. Files need to contain at least one image", tierCount)
+	} else if tierCount > 2 {
+		log.Warnf("The games only use files with up to two images, so the %d-image file is for experiments only", tierCount)
+	}
+
+	if _, ok := mtx.ResampleFilter(resampleFilter); !ok {
+		return fmt.Errorf("unsupported filter %q. Supported filters are: %s", resampleFilter, joinResampleFilters())
+	} else if sharpenSigma < 0 {
+		return fmt.Errorf("a sharpening strength of %g is unsupported. It can't be negative", sharpenSigma)
+	}
+
+	for _, p := range mtx.OddSizePolicies() {
+		if string(p) == oddSizePolicy {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported odd size policy %q. Supported policies are: %s", oddSizePolicy, joinOddSizePolicies())
+}
+
+func joinResampleFilters() string {
+	return strings.Join(mtx.ResampleFilterNames(), ", ")
+}
+
+func joinOddSizePolicies() string {
+	names := make([]string, len(mtx.OddSizePolicies()))
+	for i, p := range mtx.OddSizePolicies() {
+		names[i] = string(p)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// setSmallImage replaces the smaller image of mtxFile with the one given by --small or, failing that,
+// with the @1x counterpart of file if there is one
+func setSmallImage(mtxFile *mtx.File, file string) error {
+	smallFile := smallImagePath
+	if smallFile == "" {
+		smallFile = smallImageFor(file)
+		if _, err := os.Stat(smallFile); smallFile == "" || err != nil || len(mtxFile.Tiers) < 2 {
+			return nil
+		}
+	}
+
+	log.Infof("Using %s as the smaller image", smallFile)
+
+	f, err := openInputFile(smallFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, err := decodeImage(f)
+	if err != nil {
+		return err
+	}
+
+	return mtxFile.SetSmallTier(img)
+}
+
+// smallImageFor returns the name of the @1x counterpart of an @2x image file, like foo@1x.png for foo@2x.png.
+// It's empty for other files
+func smallImageFor(file string) string {
+	ext := filepath.Ext(file)
+	if !strings.HasSuffix(strings.TrimSuffix(file, ext), "@2x") {
+		return ""
+	}
+
+	return strings.TrimSuffix(file, "@2x"+ext) + "@1x" + ext
+}
+
+// largeImageFor returns the name of the @2x counterpart of an @1x image file. It's empty for other files
+func largeImageFor(file string) string {
+	ext := filepath.Ext(file)
+	if !strings.HasSuffix(strings.TrimSuffix(file, ext), "@1x") {
+		return ""
+	}
+
+	return strings.TrimSuffix(file, "@1x"+ext) + "@2x" + ext
+}
+
+func containsFile(files []string, file string) bool {
+	for _, f := range files {
+		if filepath.Clean(f) == filepath.Clean(file) {
+			return true
+		}
+	}
+
+	return false
+}
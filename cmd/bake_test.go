@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"mtxconv/mtx"
+)
+
+func writeTestPNG(t *testing.T, file string, width int, height int, c color.Color) {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSmallImageFor(t *testing.T) {
+	tests := map[string]string{
+		"foo@2x.png":     "foo@1x.png",
+		"dir/foo@2x.jpg": "dir/foo@1x.jpg",
+		"foo.png":        "",
+		"foo@1x.png":     "",
+	}
+
+	for file, want := range tests {
+		if got := smallImageFor(file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestSetSmallImagePicksUpSmallImage(t *testing.T) {
+	dir := t.TempDir()
+	largeFile := filepath.Join(dir, "foo@2x.png")
+	writeTestPNG(t, largeFile, 64, 32, color.Black)
+
+	for _, test := range []struct {
+		name          string
+		width, height int
+		wantErr       bool
+	}{
+		{"matching", 32, 16, false},
+		{"mismatching", 40, 16, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			writeTestPNG(t, filepath.Join(dir, "foo@1x.png"), test.width, test.height, color.White)
+
+			mtxFile, err := mtx.NewFile(1, image.NewNRGBA(image.Rect(0, 0, 64, 32)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = setSmallImage(mtxFile, largeFile)
+			if test.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if r, _, _, _ := mtxFile.Tiers[0].Image().At(16, 8).RGBA(); r < 0xF000 {
+				t.Error("foo@1x.png wasn't used as the smaller image")
+			}
+		})
+	}
+}
+
+func TestSetSmallImageWithoutSmallImage(t *testing.T) {
+	largeFile := filepath.Join(t.TempDir(), "foo@2x.png")
+	writeTestPNG(t, largeFile, 64, 32, color.Black)
+
+	mtxFile, err := mtx.NewFile(1, image.NewNRGBA(image.Rect(0, 0, 64, 32)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setSmallImage(mtxFile, largeFile); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBakeV2UnknownOverride(t *testing.T) {
+	defer log.StandardLogger().ReplaceHooks(log.StandardLogger().ReplaceHooks(make(log.LevelHooks)))
+	hook := test.NewGlobal()
+	defer func(unknown int) { v2Unknown = unknown }(v2Unknown)
+
+	// rewrap a legacy PVR texture so the test doesn't depend on the PVRTC encoder
+	header := mtx.PVRTC2Header{
+		HeaderSize:         mtx.PVRTC2_HEADER_SIZE,
+		Height:             8,
+		Width:              8,
+		PixelFormatFlags:   mtx.PVR_OGL_PVRTC4,
+		CompressedDataSize: 32,
+		BitCount:           4,
+		Magic:              mtx.FourCC{'P', 'V', 'R', '!'},
+		NumSurfaces:        1,
+	}
+	pvrBuf := bytes.Buffer{}
+	if err := mtx.EncodePVR(&pvrBuf, header, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unknown := range []int{mtx.DEFAULT_V2_UNKNOWN, 7} {
+		file := filepath.Join(t.TempDir(), "foo.pvr")
+		if err := os.WriteFile(file, pvrBuf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		v2Unknown = unknown
+		hook.Reset()
+		if err := bakeFile(file, 2); err != nil {
+			t.Fatalf("%d: %v", unknown, err)
+		}
+
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || entry.Level == log.WarnLevel
+		}
+		if warned != (unknown != mtx.DEFAULT_V2_UNKNOWN) {
+			t.Errorf("%d: got warned %t, want %t", unknown, warned, unknown != mtx.DEFAULT_V2_UNKNOWN)
+		}
+
+		mtxData, err := os.ReadFile(file + ".mtx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtxFile, err := mtx.Decode(bytes.NewReader(mtxData)); err != nil {
+			t.Fatal(err)
+		} else if mtxFile.HeaderV2.Unknown != uint16(unknown) {
+			t.Errorf("%d: wrote %d", unknown, mtxFile.HeaderV2.Unknown)
+		}
+	}
+}
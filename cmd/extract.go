@@ -1,12 +1,49 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"mtxconv/mtx"
 )
 
+var (
+	pngEnc = png.Encoder{
+		CompressionLevel: png.BestSpeed,
+	}
+
+	extractSidecarEnabled bool
+	extractFormat         string
+	extractContainer      string
+	linearToSRGBEnabled   bool
+	extractMipsEnabled    bool
+)
+
+const (
+	sidecarSuffix = ".sidecar.json"
+)
+
+// textureContainers maps the containers MTXv2 textures can be extracted to to their file extensions and encoders
+var textureContainers = map[string]struct {
+	ext    string
+	encode func(io.Writer, mtx.PVRTC2Header, []byte) error
+}{
+	"pvr":  {"pvr", mtx.EncodePVR},
+	"pvr3": {"pvr", mtx.EncodePVR3},
+	"ktx":  {"ktx", mtx.EncodeKTX},
+	"ktx2": {"ktx2", mtx.EncodeKTX2},
+	"dds":  {"dds", mtx.EncodeDDS},
+}
+
 // extractCmd represents the extract command
 var extractCmd = &cobra.Command{
 	Use:   "extract [MTX files]",
@@ -19,7 +56,7 @@ var extractCmd = &cobra.Command{
 
 		for _, file := range args {
 			log.Info(file)
-			if err := mtx.ExtractMTXFile(file, dryRunEnabled); err != nil {
+			if err := extractFile(file); err != nil {
 				log.Error(err)
 			}
 			fmt.Println()
@@ -28,5 +65,164 @@ var extractCmd = &cobra.Command{
 }
 
 func init() {
+	extractCmd.Flags().BoolVarP(&extractSidecarEnabled, "sidecar", "", false, "also write a sidecar file that allows repacking the MTX file losslessly")
+	extractCmd.Flags().StringVarP(&extractFormat, "format", "", "pvr", "output format for MTXv2 textures. Needs to be one of pvr, pvr3 or png")
+	extractCmd.Flags().StringVarP(&extractContainer, "container", "", "", "container to store MTXv2 textures in without decoding them. One of pvr, pvr3, ktx, ktx2 or dds. Overrides --format")
+	extractCmd.Flags().BoolVarP(&extractMipsEnabled, "mips", "", false, "write every mip level and surface of MTXv2 textures to its own PNG file when extracting them as PNG")
+	extractCmd.Flags().BoolVarP(&linearToSRGBEnabled, "srgb", "", false, "convert MTXv2 textures storing linear-light colors to sRGB when extracting them as PNG")
 	rootCmd.AddCommand(extractCmd)
 }
+
+func newSidecarImage(name string, data []byte) mtx.SidecarImage {
+	return mtx.SidecarImage{
+		Name:   name,
+		SHA256: fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+}
+
+// tierNumber returns the number used in output file names for the tier at index i.
+// Files that omit the smaller tier still name the remaining one after its slot
+func tierNumber(mtxFile *mtx.File, i int) int {
+	if len(mtxFile.Tiers) == 1 {
+		return 2
+	}
+
+	return i + 1
+}
+
+func extractFile(file string) error {
+	f, err := openInputFile(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mtxFile, err := mtx.DecodeWithLimits(f, &resourceLimits)
+	if err != nil {
+		return err
+	}
+
+	container := extractFormat
+	if extractContainer != "" {
+		container = extractContainer
+	}
+	if mtxFile.Version == 2 {
+		if _, ok := textureContainers[extractContainer]; !ok && extractContainer != "" {
+			return fmt.Errorf("a container of %q is unsupported. Supported values are: pvr, pvr3, ktx, ktx2 and dds", extractContainer)
+		} else if _, ok := textureContainers[container]; !ok && container != "png" {
+			return fmt.Errorf("an output format of %q is unsupported. Supported values are: pvr, pvr3 and png", extractFormat)
+		} else if container != "pvr" && extractSidecarEnabled {
+			return errors.New("sidecars for MTXv2 files can only be written when extracting the legacy PVR texture")
+		}
+	}
+
+	// set up paths and file names
+	fileDir, fileBaseNoExt := splitFileName(file)
+	sidecar := mtx.NewSidecar(mtxFile, filepath.Base(file))
+
+	switch mtxFile.Version {
+	case 0:
+		for i, tier := range mtxFile.Tiers {
+			imageIndex := tierNumber(mtxFile, i)
+			newOutFileName := fmt.Sprintf("%s%d.jpg", fileBaseNoExt, imageIndex)
+
+			log.Infof("Extracting image %d…", imageIndex)
+			if err := writeOutputFile(filepath.Join(fileDir, newOutFileName), tier.RawColor, dryRunEnabled); err != nil {
+				return err
+			}
+			sidecar.Tiers[i].Image = newSidecarImage(newOutFileName, tier.RawColor)
+		}
+	case 1:
+		for i, tier := range mtxFile.Tiers {
+			imageIndex := tierNumber(mtxFile, i)
+			newOutFileName := fmt.Sprintf("%s%d.png", fileBaseNoExt, imageIndex)
+
+			log.Infof("Extracting image %d…", imageIndex)
+			imgBuf := new(bytes.Buffer)
+			if err := pngEnc.Encode(imgBuf, tier.Image()); err != nil {
+				return err
+			}
+			if err := writeOutputFile(filepath.Join(fileDir, newOutFileName), imgBuf.Bytes(), dryRunEnabled); err != nil {
+				return err
+			}
+			sidecar.Tiers[i].Image = newSidecarImage(newOutFileName, imgBuf.Bytes())
+		}
+	case 2:
+		if container == "png" {
+			// cutting the name at its first dot could turn "foo.png.mtx" into the name of its source image
+			return extractPVRImages(mtxFile, fileDir, strings.TrimSuffix(filepath.Base(file), ".mtx"))
+		}
+
+		newOutFileName := fmt.Sprintf("%s.%s", fileBaseNoExt, textureContainers[container].ext)
+
+		log.Info("Extracting image…")
+		pvrBuf := new(bytes.Buffer)
+		if err := textureContainers[container].encode(pvrBuf, mtxFile.PVRHeader, mtxFile.PVRData); err != nil {
+			return err
+		}
+		if err := writeOutputFile(filepath.Join(fileDir, newOutFileName), pvrBuf.Bytes(), dryRunEnabled); err != nil {
+			return err
+		}
+		*sidecar.PVR = newSidecarImage(newOutFileName, pvrBuf.Bytes())
+	}
+
+	if extractSidecarEnabled {
+		log.Info("Writing sidecar…")
+		sidecarData, err := json.MarshalIndent(sidecar, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeOutputFile(filepath.Join(fileDir, fileBaseNoExt+sidecarSuffix), sidecarData, dryRunEnabled); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Done.")
+
+	return nil
+}
+
+// extractPVRImages decodes the texture of an MTXv2 file and writes its largest mip level to fileBase_mip0.png.
+// If extractMipsEnabled is set, every mip level of every surface is written to its own file
+func extractPVRImages(mtxFile *mtx.File, fileDir string, fileBase string) error {
+	header := mtxFile.PVRHeader
+	levels, ok := header.Levels()
+	if !ok {
+		return fmt.Errorf("decoding %s textures is unsupported", header.PixelTypeName())
+	} else if !extractMipsEnabled {
+		levels = levels[:1]
+	}
+
+	for _, level := range levels {
+		newOutFileName := fmt.Sprintf("%s_mip%d.png", fileBase, level.Level)
+		if extractMipsEnabled {
+			log.Infof("Decoding %s texture, surface %d, mip %d (%dx%d)…", header.PixelTypeName(), level.Surface, level.Level, level.Width, level.Height)
+			if header.NumSurfaces > 1 {
+				newOutFileName = fmt.Sprintf("%s_surface%d_mip%d.png", fileBase, level.Surface, level.Level)
+			}
+		} else {
+			log.Infof("Decoding %s texture…", header.PixelTypeName())
+		}
+
+		img, err := mtx.DecodePVRLevel(header, mtxFile.PVRData, level)
+		if err != nil {
+			return err
+		}
+
+		if linearToSRGBEnabled {
+			mtx.LinearToSRGB(img)
+		}
+
+		imgBuf := new(bytes.Buffer)
+		if err := pngEnc.Encode(imgBuf, img); err != nil {
+			return err
+		}
+		if err := writeOutputFile(filepath.Join(fileDir, newOutFileName), imgBuf.Bytes(), dryRunEnabled); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Done.")
+
+	return nil
+}
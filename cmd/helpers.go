@@ -1,6 +1,18 @@
 package cmd
 
-import log "github.com/sirupsen/logrus"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	log "github.com/sirupsen/logrus"
+)
 
 func commandPreflight(debugMode bool) {
 	log.SetFormatter(&log.TextFormatter{
@@ -13,3 +25,74 @@ func commandPreflight(debugMode bool) {
 		log.Debug("Debug flag set!")
 	}
 }
+
+// openInputFile opens file for reading and performs preliminary type and size checks
+func openInputFile(file string) (*os.File, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.New("couldn't get file info")
+	} else if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, errors.New("is a directory")
+	} else if err := resourceLimits.CheckFileSize(fi.Size()); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// decodeImage decodes an image file, making sure its dimensions don't exceed the limits before decoding it
+func decodeImage(r io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	} else if err := resourceLimits.CheckImage(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return imaging.Decode(r)
+}
+
+// splitFileName returns the directory of file and its base name up to the first dot
+func splitFileName(file string) (string, string) {
+	fileDir, fileBase := filepath.Split(file)
+	return fileDir, strings.Split(fileBase, ".")[0]
+}
+
+// checkOverwrite refuses to overwrite an existing file at outFilePath unless --force is set
+func checkOverwrite(outFilePath string) error {
+	if forceEnabled {
+		return nil
+	} else if _, err := os.Lstat(outFilePath); err == nil {
+		return fmt.Errorf("%s already exists. Set --force to overwrite it", outFilePath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// writeOutputFile writes data to outFilePath unless dryRun is set. Existing files are only overwritten if --force is set
+func writeOutputFile(outFilePath string, data []byte, dryRun bool) error {
+	if err := checkOverwrite(outFilePath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(outFilePath))
+		return nil
+	}
+
+	return os.WriteFile(outFilePath, data, 0644)
+}
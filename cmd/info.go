@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"mtxconv/mtx"
+)
+
+var (
+	infoJSONEnabled   bool
+	infoSurveyEnabled bool
+)
+
+// fileInfo is the JSON representation of a single file's info
+type fileInfo struct {
+	File  string `json:"file"`
+	Error string `json:"error,omitempty"`
+	*mtx.Info
+}
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info [MTX files or directories]",
+	Short: "Print the headers and layout of MTX files",
+
+	Args: cobra.MinimumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		commandPreflight(debugModeEnabled)
+
+		if infoSurveyEnabled {
+			if err := surveyFiles(args); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		infos := make([]fileInfo, 0, len(args))
+		for _, file := range args {
+			info, err := inspectFile(file)
+			if err != nil {
+				log.Errorf("%s: %v", file, err)
+				infos = append(infos, fileInfo{File: file, Error: err.Error()})
+				continue
+			}
+
+			infos = append(infos, fileInfo{File: file, Info: info})
+			if !infoJSONEnabled {
+				printInfo(file, info)
+			}
+		}
+
+		if infoJSONEnabled {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(infos); err != nil {
+				log.Error(err)
+			}
+		}
+	},
+}
+
+func init() {
+	infoCmd.Flags().BoolVarP(&infoJSONEnabled, "json", "", false, "print machine-readable JSON instead of text")
+	infoCmd.Flags().BoolVarP(&infoSurveyEnabled, "survey", "", false, "summarize the header values of all files instead of printing each file's info. Directories are searched for MTX files recursively")
+	rootCmd.AddCommand(infoCmd)
+}
+
+func inspectFile(file string) (*mtx.Info, error) {
+	f, err := openInputFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mtxFile, err := mtx.DecodeWithLimits(f, &resourceLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	return mtxFile.Info(), nil
+}
+
+func printInfo(file string, info *mtx.Info) {
+	fmt.Println(file)
+	fmt.Printf("  Version:            MTXv%d\n", info.Version)
+
+	if info.Header != nil {
+		fmt.Printf("  Header:             Magic %d, LengthFirst %d, LengthSecond %d\n", info.Header.Magic, info.Header.LengthFirst, info.Header.LengthSecond)
+	}
+	if info.HeaderV2 != nil {
+		fmt.Printf("  Header:             Magic %d, Unknown %d\n", info.HeaderV2.Magic, info.HeaderV2.Unknown)
+	}
+
+	if info.OddSize != "" {
+		fmt.Printf("  Odd sizes:          %s\n", info.OddSize)
+	}
+	for i, tier := range info.Tiers {
+		fmt.Printf("  Tier %d:             %d bytes at offset 0x%X\n", i+1, tier.Size, tier.Offset)
+		if info.Version == 1 {
+			fmt.Printf("    Block header:     %dx%d\n", tier.Width, tier.Height)
+		}
+		passedThrough := ""
+		if tier.PassedThrough {
+			passedThrough = " (passed through)"
+		}
+		fmt.Printf("    JPEG:             %d bytes, %dx%d%s\n", tier.JPEGSize, tier.JPEGWidth, tier.JPEGHeight, passedThrough)
+		if info.Version == 1 {
+			fmt.Printf("    Mask:             %d bytes, %d bytes decompressed\n", tier.MaskSize, tier.MaskDecompressedSize)
+		}
+	}
+
+	if pvr := info.PVR; pvr != nil {
+		h := pvr.Header
+		fmt.Println("  PVR header:")
+		fmt.Printf("    HeaderSize:       %d\n", h.HeaderSize)
+		fmt.Printf("    Width:            %d\n", h.Width)
+		fmt.Printf("    Height:           %d\n", h.Height)
+		fmt.Printf("    MipMapCount:      %d\n", h.MipMapCount)
+		fmt.Printf("    PixelFormatFlags: 0x%08X (%s; flags: %s)\n", h.PixelFormatFlags, pvr.PixelType, formatFlags(pvr.Flags))
+		fmt.Printf("    DataSize:         %d\n", h.CompressedDataSize)
+		fmt.Printf("    BitCount:         %d\n", h.BitCount)
+		fmt.Printf("    BitMasks:         R 0x%08X, G 0x%08X, B 0x%08X, A 0x%08X\n", h.BitMaskR, h.BitMaskG, h.BitMaskB, h.BitMaskA)
+		fmt.Printf("    Magic:            %q\n", h.Magic.String())
+		fmt.Printf("    NumSurfaces:      %d\n", h.NumSurfaces)
+		fmt.Printf("  PVR data:           %d bytes\n", pvr.DataSize)
+		for _, level := range pvr.Levels {
+			missing := ""
+			if level.Offset+level.Size > pvr.DataSize {
+				missing = " (missing)"
+			}
+			fmt.Printf("    Surface %d, mip %d: %dx%d, %d bytes at offset 0x%X%s\n", level.Surface, level.Level, level.Width, level.Height, level.Size, level.Offset, missing)
+		}
+	}
+
+	fmt.Printf("  Trailing data:      %d bytes\n", info.TrailingBytes)
+	fmt.Println()
+}
+
+func formatFlags(flags []string) string {
+	if len(flags) == 0 {
+		return "none"
+	}
+
+	return strings.Join(flags, ", ")
+}
+
+// surveyResult summarizes the header values found across many MTX files
+type surveyResult struct {
+	Files    int                  `json:"files"`
+	Errors   int                  `json:"errors"`
+	Versions map[uint32]int       `json:"versions"`
+	Unknown  map[uint16]*surveyed `json:"v2Unknown"` // values of HeaderV2.Unknown
+}
+
+// surveyed counts the files sharing a header value
+type surveyed struct {
+	Count   int    `json:"count"`
+	Example string `json:"example"` // the first file found with the value
+}
+
+// collectMTXFiles returns the files named by args. Directories are replaced by the MTX files found in them
+func collectMTXFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".mtx") {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// surveyFiles prints how often each value of the MTXv2 header's unknown field occurs in the files named by args
+func surveyFiles(args []string) error {
+	files, err := collectMTXFiles(args)
+	if err != nil {
+		return err
+	}
+
+	// the reader's warnings about individual files would drown out the summary
+	if !debugModeEnabled {
+		log.SetLevel(log.ErrorLevel)
+	}
+
+	result := surveyResult{Versions: map[uint32]int{}, Unknown: map[uint16]*surveyed{}}
+	for _, file := range files {
+		result.Files++
+
+		f, err := openInputFile(file)
+		if err != nil {
+			log.Errorf("%s: %v", file, err)
+			result.Errors++
+			continue
+		}
+		mtxFile, err := mtx.DecodeWithLimits(f, &resourceLimits)
+		f.Close()
+		if err != nil {
+			log.Errorf("%s: %v", file, err)
+			result.Errors++
+			continue
+		}
+
+		result.Versions[mtxFile.Version]++
+		if mtxFile.Version == 2 {
+			value, ok := result.Unknown[mtxFile.HeaderV2.Unknown]
+			if !ok {
+				value = &surveyed{Example: file}
+				result.Unknown[mtxFile.HeaderV2.Unknown] = value
+			}
+			value.Count++
+		}
+	}
+
+	if infoJSONEnabled {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	fmt.Printf("Surveyed %d file(s), %d of which couldn't be read\n", result.Files, result.Errors)
+	for version := uint32(0); version <= 2; version++ {
+		fmt.Printf("  MTXv%d:              %d file(s)\n", version, result.Versions[version])
+	}
+
+	values := make([]int, 0, len(result.Unknown))
+	for value := range result.Unknown {
+		values = append(values, int(value))
+	}
+	sort.Ints(values)
+
+	fmt.Println("  MTXv2 Unknown field:")
+	for _, value := range values {
+		v := result.Unknown[uint16(value)]
+		fmt.Printf("    %-17s %d file(s), e.g. %s\n", fmt.Sprintf("%d:", value), v.Count, v.Example)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"mtxconv/mtx"
+)
+
+var (
+	repackJPEGQuality int
+	repackOutputDir   string
+)
+
+// repackCmd represents the repack command
+var repackCmd = &cobra.Command{
+	Use:   "repack [sidecar files]",
+	Short: "Rebuild MTX files from extracted images and their sidecars",
+	Long: `Rebuild MTX files from images extracted with "extract --sidecar".
+Unmodified images are repacked using their original data, so the rebuilt file is identical to the original.
+Only the color data or masks of modified images are re-encoded.
+The original MTX file is only overwritten if --force is set, so write the rebuilt file elsewhere with --output.`,
+
+	Args: cobra.MinimumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		commandPreflight(debugModeEnabled)
+
+		for _, file := range args {
+			log.Info(file)
+			if err := repackFile(file); err != nil {
+				log.Error(err)
+			}
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	repackCmd.Flags().IntVarP(&repackJPEGQuality, "jpeg-quality", "q", mtx.DefaultJPEGQuality, fmt.Sprintf("JPEG quality used for modified images (Default %d)", mtx.DefaultJPEGQuality))
+	repackCmd.Flags().StringVarP(&repackOutputDir, "output", "o", "", "directory to write the rebuilt MTX files to. Defaults to the directories of their sidecars")
+	rootCmd.AddCommand(repackCmd)
+}
+
+// sidecarFileName returns name, a file name taken from a sidecar, if it names a file in the sidecar's directory.
+// Sidecars only ever store base names, so anything else would read or write files elsewhere
+func sidecarFileName(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return "", fmt.Errorf("the sidecar's file name %q isn't a plain file name", name)
+	}
+
+	return name, nil
+}
+
+// readSidecarImage reads an image file referenced by a sidecar and reports whether it was modified since extraction
+func readSidecarImage(sidecarDir string, image mtx.SidecarImage) ([]byte, bool, error) {
+	name, err := sidecarFileName(image.Name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f, err := openInputFile(filepath.Join(sidecarDir, name))
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, fmt.Sprintf("%x", sha256.Sum256(data)) != image.SHA256, nil
+}
+
+func repackFile(file string) error {
+	sidecarData, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	sidecar := &mtx.Sidecar{}
+	if err := json.Unmarshal(sidecarData, sidecar); err != nil {
+		return err
+	}
+
+	sidecarDir := filepath.Dir(file)
+	outDir := sidecarDir
+	if repackOutputDir != "" {
+		outDir = repackOutputDir
+	}
+
+	outFileName, err := sidecarFileName(sidecar.File)
+	if err != nil {
+		return err
+	}
+	outFilePath := filepath.Join(outDir, outFileName)
+	if err := checkOverwrite(outFilePath); err != nil {
+		return err
+	}
+
+	var mtxFile *mtx.File
+	switch sidecar.Version {
+	case 0, 1:
+		if mtxFile, err = sidecar.Restore(&resourceLimits); err != nil {
+			return err
+		}
+
+		for i, tier := range mtxFile.Tiers {
+			imageIndex := i + 1
+			imgData, modified, err := readSidecarImage(sidecarDir, sidecar.Tiers[i].Image)
+			if err != nil {
+				return err
+			} else if !modified {
+				log.Debugf("Image %d is unchanged", imageIndex)
+				continue
+			}
+
+			img, err := decodeImage(bytes.NewReader(imgData))
+			if err != nil {
+				return err
+			}
+
+			colorChanged, maskChanged := tier.UpdateImage(img, sidecar.Version == 1)
+			if colorChanged && sidecar.Version == 0 && isJPEGData(imgData) {
+				// MTXv0 tiers are plain JPEG files, so modified ones can be embedded as they are
+				tier.RawColor = imgData
+			}
+
+			log.Infof("Image %d: color %s, mask %s", imageIndex, describeChange(colorChanged), describeChange(maskChanged))
+		}
+	case 2:
+		pvrData, _, err := readSidecarImage(sidecarDir, *sidecar.PVR)
+		if err != nil {
+			return err
+		}
+
+		pvrHeader, pvrPayload, err := mtx.DecodePVRWithLimits(bytes.NewReader(pvrData), &resourceLimits)
+		if err != nil {
+			return err
+		}
+
+		mtxFile = mtx.NewPVRFile(pvrHeader, pvrPayload)
+		mtxFile.Trailing = sidecar.Trailing
+		if sidecar.HeaderV2 != nil {
+			mtxFile.HeaderV2.Unknown = sidecar.HeaderV2.Unknown
+		}
+	default:
+		return fmt.Errorf("unsupported MTX version %d in sidecar", sidecar.Version)
+	}
+
+	opts := &mtx.BakeOptions{
+		JPEGQuality: repackJPEGQuality,
+		Limits:      &resourceLimits,
+		DryRun:      dryRunEnabled,
+		OutputPath:  outFilePath,
+	}
+
+	log.Infof("Writing %s…", outFilePath)
+	_, err = mtx.WriteFile(mtxFile, opts)
+	return err
+}
+
+func isJPEGData(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0xFF, 0xD8})
+}
+
+func describeChange(changed bool) string {
+	if changed {
+		return "re-encoded"
+	}
+
+	return "kept"
+}
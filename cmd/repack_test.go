@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mtxconv/mtx"
+)
+
+// extractTestFile writes an MTXv1 file to dir and extracts it along with its sidecar.
+// It returns the contents of the MTX file and the path of its sidecar
+func extractTestFile(t *testing.T, dir string) ([]byte, string) {
+	defer func(enabled bool) { extractSidecarEnabled = enabled }(extractSidecarEnabled)
+	extractSidecarEnabled = true
+
+	mtxFile, err := mtx.NewFile(1, image.NewNRGBA(image.Rect(0, 0, 64, 32)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	if err := mtx.Encode(&buf, mtxFile, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "foo.mtx")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := extractFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes(), filepath.Join(dir, "foo"+sidecarSuffix)
+}
+
+func TestRepackKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	data, sidecarFile := extractTestFile(t, dir)
+
+	if err := repackFile(sidecarFile); err == nil {
+		t.Error("the original file was overwritten without --force")
+	}
+
+	defer func(dir string) { repackOutputDir = dir }(repackOutputDir)
+	repackOutputDir = t.TempDir()
+	if err := repackFile(sidecarFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if repacked, err := os.ReadFile(filepath.Join(repackOutputDir, "foo.mtx")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(repacked, data) {
+		t.Error("the repacked file differs from the original")
+	}
+}
+
+func TestRepackRejectsEscapingPaths(t *testing.T) {
+	defer func(enabled bool) { forceEnabled = enabled }(forceEnabled)
+	forceEnabled = true
+
+	for _, name := range []string{"../foo.mtx", "sub/foo.mtx", "/tmp/foo.mtx", "..", ""} {
+		dir := t.TempDir()
+		_, sidecarFile := extractTestFile(t, dir)
+
+		sidecarData, err := os.ReadFile(sidecarFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sidecar := map[string]any{}
+		if err := json.Unmarshal(sidecarData, &sidecar); err != nil {
+			t.Fatal(err)
+		}
+		sidecar["file"] = name
+		if sidecarData, err = json.Marshal(sidecar); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(sidecarFile, sidecarData, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repackFile(sidecarFile); err == nil {
+			t.Errorf("%q: got no error", name)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"mtxconv/mtx"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -13,6 +14,10 @@ var rootCmd = &cobra.Command{
 var (
 	debugModeEnabled bool
 	dryRunEnabled    bool
+	forceEnabled     bool
+
+	// resourceLimits is filled in by the --max-* flags and applies to every command
+	resourceLimits mtx.Limits
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -28,4 +33,14 @@ func init() {
 
 	rootCmd.PersistentFlags().BoolVarP(&debugModeEnabled, "debug", "", false, "debug")
 	rootCmd.PersistentFlags().BoolVarP(&dryRunEnabled, "dry-run", "", false, "disables writing of output files. useful for testing")
+	rootCmd.PersistentFlags().BoolVarP(&forceEnabled, "force", "", false, "overwrite existing output files")
+
+	// negative values disable a limit
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxWidth, "max-width", "", mtx.DefaultMaxDimension, "maximum image width, or -1 for no limit")
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxHeight, "max-height", "", mtx.DefaultMaxDimension, "maximum image height, or -1 for no limit")
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxPixels, "max-pixels", "", mtx.DefaultMaxPixels, "maximum number of pixels per image, or -1 for no limit")
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxMaskBytes, "max-mask-bytes", "", mtx.DefaultMaxMaskBytes, "maximum decompressed size of MTXv1 masks, or -1 for no limit")
+	rootCmd.PersistentFlags().Int64VarP(&resourceLimits.MaxFileSize, "max-file-size", "", mtx.DefaultMaxFileSize, "maximum size of input and output files in bytes, or -1 for no limit")
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxTiers, "max-tiers", "", mtx.DefaultMaxTiers, "maximum number of tiers per MTXv0 or MTXv1 file, or -1 for no limit")
+	rootCmd.PersistentFlags().IntVarP(&resourceLimits.MaxSurfaces, "max-surfaces", "", mtx.DefaultMaxSurfaces, "maximum number of surfaces per texture, counting cube map faces, or -1 for no limit")
 }
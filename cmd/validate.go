@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"mtxconv/mtx"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [MTX files]",
+	Short: "Check MTX files for structural problems",
+
+	Args: cobra.MinimumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		commandPreflight(debugModeEnabled)
+
+		failed := false
+		for _, file := range args {
+			log.Info(file)
+			problems, err := validateFile(file)
+			if err != nil {
+				log.Error(err)
+				failed = true
+			} else if len(problems) == 0 {
+				log.Info("No problems found.")
+			} else {
+				for _, problem := range problems {
+					log.Error(problem)
+				}
+				log.Errorf("%d problem(s) found.", len(problems))
+				failed = true
+			}
+			fmt.Println()
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+func validateFile(file string) ([]*mtx.ParseError, error) {
+	f, err := openInputFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return mtx.ValidateWithLimits(f, &resourceLimits)
+}
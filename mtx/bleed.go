@@ -0,0 +1,177 @@
+package mtx
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+/*
+MTXv1 files store colors and transparency separately, so the colors of invisible pixels end up in the JPEG data.
+Left as they are, they're often black and bleed into visible pixels when the game filters the texture,
+which shows up as dark halos. Filling invisible pixels with the colors of nearby visible ones avoids that
+and usually makes the JPEG data smaller, too
+*/
+
+// BleedStrategy names a way of filling the colors of invisible pixels before they're JPEG-encoded
+type BleedStrategy string
+
+const (
+	BleedNone   BleedStrategy = "none"   // keep the colors as they are
+	BleedDilate BleedStrategy = "dilate" // extend the colors of visible pixels outwards
+	BleedBlur   BleedStrategy = "blur"   // like BleedDilate, but blurred away from edges to soften the streaks dilation leaves
+	BleedSolid  BleedStrategy = "solid"  // fill invisible pixels with the average color of visible ones
+)
+
+// bleedStrategies lists all supported BleedStrategies
+var bleedStrategies = [...]BleedStrategy{
+	BleedDilate,
+	BleedBlur,
+	BleedSolid,
+	BleedNone,
+}
+
+// BleedStrategies returns all supported BleedStrategies
+func BleedStrategies() []BleedStrategy {
+	return append([]BleedStrategy(nil), bleedStrategies[:]...)
+}
+
+const (
+	bleedBlurSigma    = 4 // strength of the blur applied by BleedBlur
+	bleedBlurDistance = 2 // number of pixels around visible ones BleedBlur doesn't blur, so edges keep their colors
+)
+
+// bleedColors fills the colors of img's invisible pixels using strategy. Pixels are invisible if their
+// value in mask is zero, or their alpha value if mask is nil. Visible pixels are left untouched
+func bleedColors(img *image.NRGBA, mask *image.Gray, strategy BleedStrategy) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	visible := make([]bool, width*height)
+	anyVisible, anyInvisible := false, false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if mask != nil {
+				visible[y*width+x] = mask.Pix[y*mask.Stride+x] != 0
+			} else {
+				visible[y*width+x] = img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)+3] != 0
+			}
+
+			anyVisible = anyVisible || visible[y*width+x]
+			anyInvisible = anyInvisible || !visible[y*width+x]
+		}
+	}
+
+	if !anyVisible || !anyInvisible {
+		return
+	}
+
+	switch strategy {
+	case BleedDilate:
+		dilateColors(img, visible)
+	case BleedBlur:
+		distances := dilateColors(img, visible)
+
+		// imaging weights colors by their alpha value while blurring, so invisible pixels would turn black
+		opaque := imaging.Clone(img)
+		makeAlphaChannelOpaque(opaque)
+		blurred := imaging.Blur(opaque, bleedBlurSigma)
+		for i, d := range distances {
+			if d > bleedBlurDistance {
+				x, y := i%width, i/width
+				copy(img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):][:3], blurred.Pix[blurred.PixOffset(x, y):])
+			}
+		}
+	case BleedSolid:
+		var sum [3]int
+		count := 0
+		for i, v := range visible {
+			if v {
+				offset := img.PixOffset(img.Rect.Min.X+i%width, img.Rect.Min.Y+i/width)
+				for c := range sum {
+					sum[c] += int(img.Pix[offset+c])
+				}
+				count++
+			}
+		}
+
+		for i, v := range visible {
+			if !v {
+				offset := img.PixOffset(img.Rect.Min.X+i%width, img.Rect.Min.Y+i/width)
+				for c := range sum {
+					img.Pix[offset+c] = uint8((sum[c] + count/2) / count)
+				}
+			}
+		}
+	}
+}
+
+// dilateColors fills invisible pixels ring by ring, starting next to visible ones.
+// Each pixel gets the average color of its already filled neighbors.
+// It returns the distance of each pixel to the nearest visible one in rings, which is 0 for visible pixels
+func dilateColors(img *image.NRGBA, visible []bool) []int {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	filled := append([]bool(nil), visible...)
+	distances := make([]int, width*height)
+
+	// neighbors calls f for every pixel surrounding i
+	neighbors := func(i int, f func(n int)) {
+		x, y := i%width, i/width
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && x+dx >= 0 && x+dx < width && y+dy >= 0 && y+dy < height {
+					f((y+dy)*width + x + dx)
+				}
+			}
+		}
+	}
+
+	var ring []int
+	queued := make([]bool, width*height)
+	for i, v := range visible {
+		if !v {
+			continue
+		}
+		neighbors(i, func(n int) {
+			if !filled[n] && !queued[n] {
+				ring, queued[n] = append(ring, n), true
+			}
+		})
+	}
+
+	for distance := 1; len(ring) > 0; distance++ {
+		colors := make([][3]uint8, len(ring))
+		for r, i := range ring {
+			var sum [3]int
+			count := 0
+			neighbors(i, func(n int) {
+				if filled[n] {
+					offset := img.PixOffset(img.Rect.Min.X+n%width, img.Rect.Min.Y+n/width)
+					for c := range sum {
+						sum[c] += int(img.Pix[offset+c])
+					}
+					count++
+				}
+			})
+			for c := range sum {
+				colors[r][c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+
+		// only fill the ring once all of its colors are known, so they don't depend on the order they're computed in
+		var next []int
+		for r, i := range ring {
+			copy(img.Pix[img.PixOffset(img.Rect.Min.X+i%width, img.Rect.Min.Y+i/width):], colors[r][:])
+			filled[i] = true
+			distances[i] = distance
+		}
+		for _, i := range ring {
+			neighbors(i, func(n int) {
+				if !filled[n] && !queued[n] {
+					next, queued[n] = append(next, n), true
+				}
+			})
+		}
+		ring = next
+	}
+
+	return distances
+}
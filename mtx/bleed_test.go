@@ -0,0 +1,56 @@
+package mtx
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// bleedTestImage returns a 32x32 image whose left 8 columns are visible reddish pixels. The others are invisible and black
+func bleedTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(200 + x), 60, uint8(40 + y), 0xFF})
+		}
+	}
+
+	return img
+}
+
+func TestBleedColors(t *testing.T) {
+	for _, strategy := range BleedStrategies() {
+		for _, withMask := range []bool{false, true} {
+			name := string(strategy)
+			if withMask {
+				name += " with mask"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				original := bleedTestImage()
+				img := bleedTestImage()
+				var mask *image.Gray
+				if withMask {
+					// the mask decides what's visible, so the color image's alpha channel doesn't matter
+					mask = newGrayFromRawData(getAlphaChannel(img), 32, 32)
+					makeAlphaChannelOpaque(img)
+				}
+				bleedColors(img, mask, strategy)
+
+				for y := 0; y < 32; y++ {
+					for x := 0; x < 32; x++ {
+						got, want := img.NRGBAAt(x, y), original.NRGBAAt(x, y)
+						if x < 8 || strategy == BleedNone {
+							if got.R != want.R || got.G != want.G || got.B != want.B {
+								t.Fatalf("pixel (%d, %d) changed from %v to %v", x, y, want, got)
+							}
+						} else if got.R < 190 || got.G < 50 || got.G > 70 {
+							// even the farthest pixels take after the visible ones
+							t.Fatalf("pixel (%d, %d) got %v, want a color close to the visible pixels", x, y, got)
+						}
+					}
+				}
+			})
+		}
+	}
+}
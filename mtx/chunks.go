@@ -3,7 +3,7 @@ package mtx
 import (
 	"bytes"
 	"encoding/binary"
-	"os"
+	"io"
 )
 
 /*
@@ -18,52 +18,82 @@ const (
 	BLOCK_HEADER_V1_SIZE = 12
 
 	PVRTC2_HEADER_SIZE = 52
-
-	MAX_IMAGE_BOUNDS    = 4096       // 4 KiB
-	MAX_INPUT_FILE_SIZE = 1073741824 // 1 GiB
+	PVR3_HEADER_SIZE   = 52
 )
 
+// DEFAULT_V2_UNKNOWN is the value of HeaderV2.Unknown found in every known MTXv2 file
+const DEFAULT_V2_UNKNOWN = 256
+
 // HeaderV0V1 represents a MTX v0 and v1 headers
 type HeaderV0V1 struct {
-	Magic        uint32
-	LengthFirst  uint32
-	LengthSecond uint32
+	Magic        uint32 `json:"magic"`
+	LengthFirst  uint32 `json:"lengthFirst"`
+	LengthSecond uint32 `json:"lengthSecond"`
 }
 
 // BlockHeaderV1 represents the header structure of image/mask blocks in MTXv1 files
 type BlockHeaderV1 struct {
-	Magic  uint32
-	Width  uint32
-	Height uint32
+	Magic  uint32 `json:"magic"`
+	Width  uint32 `json:"width"`
+	Height uint32 `json:"height"`
 }
 
 // HeaderV2 represents an MTX v2 header
 type HeaderV2 struct {
-	Magic   uint32
-	Unknown uint16 // appears to always be 256
+	Magic   uint32 `json:"magic"`
+	Unknown uint16 `json:"unknown"` // appears to always be 256, see DEFAULT_V2_UNKNOWN. Kept as it is when re-encoding
 }
 
 // PVRTC2Header represents the header of a PVRTC2 file. See also https://downloads.isee.biz/pub/files/igep-dsp-gst-framework-3_40_00/Graphics_SDK_4_05_00_03/GFX_Linux_SDK/OVG/SDKPackage/Utilities/PVRTexTool/Documentation/PVRTexTool.Reference%20Manual.1.11f.External.pdf
 type PVRTC2Header struct {
-	HeaderSize         uint32
-	Height             uint32
-	Width              uint32
-	MipMapCount        uint32
-	PixelFormatFlags   uint32
-	CompressedDataSize uint32
-	BitCount           uint32
-	BitMaskR           uint32
-	BitMaskG           uint32
-	BitMaskB           uint32
-	BitMaskA           uint32
-	Magic              [4]byte
-	NumSurfaces        uint32
+	HeaderSize         uint32 `json:"headerSize"`
+	Height             uint32 `json:"height"`
+	Width              uint32 `json:"width"`
+	MipMapCount        uint32 `json:"mipMapCount"`
+	PixelFormatFlags   uint32 `json:"pixelFormatFlags"`
+	CompressedDataSize uint32 `json:"compressedDataSize"`
+	BitCount           uint32 `json:"bitCount"`
+	BitMaskR           uint32 `json:"bitMaskR"`
+	BitMaskG           uint32 `json:"bitMaskG"`
+	BitMaskB           uint32 `json:"bitMaskB"`
+	BitMaskA           uint32 `json:"bitMaskA"`
+	Magic              FourCC `json:"magic"`
+	NumSurfaces        uint32 `json:"numSurfaces"`
+}
+
+// PVR3Header represents the header of a PVR v3 file, as written by current versions of PVRTexTool.
+// It's followed by MetaDataSize bytes of metadata and the texture data
+type PVR3Header struct {
+	Version      uint32 `json:"version"` // "PVR\x03" in little endian
+	Flags        uint32 `json:"flags"`
+	PixelFormat  uint64 `json:"pixelFormat"`
+	ColourSpace  uint32 `json:"colourSpace"`
+	ChannelType  uint32 `json:"channelType"`
+	Height       uint32 `json:"height"`
+	Width        uint32 `json:"width"`
+	Depth        uint32 `json:"depth"`
+	NumSurfaces  uint32 `json:"numSurfaces"`
+	NumFaces     uint32 `json:"numFaces"`
+	MIPMapCount  uint32 `json:"mipMapCount"`
+	MetaDataSize uint32 `json:"metaDataSize"`
+}
+
+// FourCC represents a four character code such as a PVR file's magic
+type FourCC [4]byte
+
+func (f FourCC) String() string {
+	return string(f[:])
+}
+
+// MarshalText makes FourCCs show up as strings in JSON output
+func (f FourCC) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
 }
 
-func readHeaderV0V1(file *os.File) (HeaderV0V1, error) {
+func readHeaderV0V1(r io.Reader) (HeaderV0V1, error) {
 	header := HeaderV0V1{}
 
-	if headerData, err := readSomeBytes(file, HEADER_V0V1_SIZE); err != nil {
+	if headerData, err := readSomeBytes(r, HEADER_V0V1_SIZE); err != nil {
 		return header, err
 	} else {
 		headerBuf := bytes.NewBuffer(headerData)
@@ -76,10 +106,10 @@ func readHeaderV0V1(file *os.File) (HeaderV0V1, error) {
 	return header, nil
 }
 
-func readBlockHeaderV1(file *os.File) (BlockHeaderV1, error) {
+func readBlockHeaderV1(r io.Reader) (BlockHeaderV1, error) {
 	header := BlockHeaderV1{}
 
-	if headerData, err := readSomeBytes(file, BLOCK_HEADER_V1_SIZE); err != nil {
+	if headerData, err := readSomeBytes(r, BLOCK_HEADER_V1_SIZE); err != nil {
 		return header, err
 	} else {
 		headerBuf := bytes.NewBuffer(headerData)
@@ -92,10 +122,10 @@ func readBlockHeaderV1(file *os.File) (BlockHeaderV1, error) {
 	return header, nil
 }
 
-func readHeaderV2(file *os.File) (HeaderV2, error) {
+func readHeaderV2(r io.Reader) (HeaderV2, error) {
 	header := HeaderV2{}
 
-	if headerData, err := readSomeBytes(file, HEADER_V2_SIZE); err != nil {
+	if headerData, err := readSomeBytes(r, HEADER_V2_SIZE); err != nil {
 		return header, err
 	} else {
 		headerBuf := bytes.NewBuffer(headerData)
@@ -108,10 +138,10 @@ func readHeaderV2(file *os.File) (HeaderV2, error) {
 	return header, nil
 }
 
-func readPVRTC2Header(file *os.File) (PVRTC2Header, error) {
+func readPVRTC2Header(r io.Reader) (PVRTC2Header, error) {
 	header := PVRTC2Header{}
 
-	if headerData, err := readSomeBytes(file, PVRTC2_HEADER_SIZE); err != nil {
+	if headerData, err := readSomeBytes(r, PVRTC2_HEADER_SIZE); err != nil {
 		return header, err
 	} else {
 		headerBuf := bytes.NewBuffer(headerData)
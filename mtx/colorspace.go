@@ -0,0 +1,31 @@
+package mtx
+
+import (
+	"image"
+	"math"
+)
+
+// linearToSRGBTable maps linear-light 8-bit values to their sRGB encoding
+var linearToSRGBTable = func() (table [256]uint8) {
+	for i := range table {
+		c := float64(i) / 255
+		if c <= 0.0031308 {
+			c *= 12.92
+		} else {
+			c = 1.055*math.Pow(c, 1/2.4) - 0.055
+		}
+		table[i] = uint8(math.Round(c * 255))
+	}
+
+	return table
+}()
+
+// LinearToSRGB converts the color channels of img from linear light to sRGB in place.
+// Some MTXv2 textures store linear-light colors and look too dark when viewed as they are
+func LinearToSRGB(img *image.NRGBA) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = linearToSRGBTable[img.Pix[i]]
+		img.Pix[i+1] = linearToSRGBTable[img.Pix[i+1]]
+		img.Pix[i+2] = linearToSRGBTable[img.Pix[i+2]]
+	}
+}
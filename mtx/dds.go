@@ -0,0 +1,260 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+DDS files store every surface with all of its mip levels one after the other, like legacy PVR files,
+so their data can be copied as is. Rows are always stored top to bottom and texture arrays need
+the DX10 header extension, which isn't supported
+*/
+
+const (
+	DDS_MAGIC       = "DDS "
+	DDS_HEADER_SIZE = 124
+
+	DDSD_CAPS        = 0x1
+	DDSD_HEIGHT      = 0x2
+	DDSD_WIDTH       = 0x4
+	DDSD_PITCH       = 0x8
+	DDSD_PIXELFORMAT = 0x1000
+	DDSD_MIPMAPCOUNT = 0x20000
+	DDSD_LINEARSIZE  = 0x80000
+
+	DDPF_ALPHAPIXELS = 0x1
+	DDPF_ALPHA       = 0x2
+	DDPF_FOURCC      = 0x4
+	DDPF_RGB         = 0x40
+	DDPF_LUMINANCE   = 0x20000
+
+	DDSCAPS_COMPLEX           = 0x8
+	DDSCAPS_TEXTURE           = 0x1000
+	DDSCAPS_MIPMAP            = 0x400000
+	DDSCAPS2_CUBEMAP          = 0x200
+	DDSCAPS2_CUBEMAP_ALLFACES = 0xFC00
+)
+
+// DDSPixelFormat describes the pixel format of a DDS file
+type DDSPixelFormat struct {
+	Size        uint32
+	Flags       uint32
+	FourCC      FourCC
+	RGBBitCount uint32
+	RBitMask    uint32
+	GBitMask    uint32
+	BBitMask    uint32
+	ABitMask    uint32
+}
+
+// DDSHeader is the header of a DDS file, following its magic
+type DDSHeader struct {
+	Size              uint32
+	Flags             uint32
+	Height            uint32
+	Width             uint32
+	PitchOrLinearSize uint32
+	Depth             uint32
+	MipMapCount       uint32
+	Reserved1         [11]uint32
+	PixelFormat       DDSPixelFormat
+	Caps              uint32
+	Caps2             uint32
+	Caps3             uint32
+	Caps4             uint32
+	Reserved2         uint32
+}
+
+// ddsPixelFormat describes the header's pixel type as a DDS pixel format
+func ddsPixelFormat(h PVRTC2Header, format textureFormat) (DDSPixelFormat, error) {
+	pixelFormat := DDSPixelFormat{Size: 32}
+	if format.isCompressed() {
+		if format.ddsFourCC == "" {
+			return pixelFormat, fmt.Errorf("%s textures can't be stored in DDS files", h.PixelTypeName())
+		}
+
+		pixelFormat.Flags = DDPF_FOURCC
+		if h.PixelFormatFlags&PVR_FLAG_ALPHA != 0 {
+			pixelFormat.Flags |= DDPF_ALPHAPIXELS
+		}
+		copy(pixelFormat.FourCC[:], format.ddsFourCC)
+		return pixelFormat, nil
+	}
+
+	masks, _ := h.bitMasks()
+	pixelFormat.RGBBitCount = uint32(masks.bitCount)
+	pixelFormat.RBitMask, pixelFormat.GBitMask, pixelFormat.BBitMask, pixelFormat.ABitMask = masks.r, masks.g, masks.b, masks.a
+	switch {
+	case masks.r|masks.g|masks.b == 0:
+		pixelFormat.Flags = DDPF_ALPHA
+	case masks.r == masks.g && masks.g == masks.b:
+		// luminance formats only store the red mask
+		pixelFormat.Flags = DDPF_LUMINANCE
+		pixelFormat.GBitMask, pixelFormat.BBitMask = 0, 0
+	default:
+		pixelFormat.Flags = DDPF_RGB
+	}
+	if masks.a != 0 && masks.r|masks.g|masks.b != 0 {
+		pixelFormat.Flags |= DDPF_ALPHAPIXELS
+	}
+
+	return pixelFormat, nil
+}
+
+// EncodeDDS converts a legacy PVR texture consisting of header and data to a DDS file and writes it to w.
+// Mip levels and cube map faces are preserved, textures with several surfaces that aren't cube maps are rejected
+func EncodeDDS(w io.Writer, header PVRTC2Header, data []byte) error {
+	format, ok := findTextureFormat(header)
+	if !ok {
+		return fmt.Errorf("%s textures can't be stored in DDS files", header.PixelTypeName())
+	}
+
+	pixelFormat, err := ddsPixelFormat(header, format)
+	if err != nil {
+		return err
+	}
+
+	elements, faces := ktxLayout(header)
+	if elements > 1 {
+		return errors.New("textures with several surfaces can't be stored in DDS files")
+	} else if header.PixelFormatFlags&PVR_FLAG_VERTICAL_FLIP != 0 {
+		return errors.New("vertically flipped textures can't be stored in DDS files, which always store rows top to bottom")
+	}
+
+	levels, err := baseLevels(header, len(data))
+	if err != nil {
+		return err
+	}
+	size, _ := header.ExpectedDataSize()
+	if len(data) < size {
+		return errors.New("PVR data is too short for the texture's dimensions")
+	}
+	if header, data, err = untwiddle(header, data); err != nil {
+		return err
+	}
+
+	ddsHeader := DDSHeader{
+		Size:        DDS_HEADER_SIZE,
+		Flags:       DDSD_CAPS | DDSD_HEIGHT | DDSD_WIDTH | DDSD_PIXELFORMAT,
+		Height:      header.Height,
+		Width:       header.Width,
+		PixelFormat: pixelFormat,
+		Caps:        DDSCAPS_TEXTURE,
+	}
+	if format.isCompressed() {
+		ddsHeader.Flags |= DDSD_LINEARSIZE
+		ddsHeader.PitchOrLinearSize = uint32(levels[0].Size)
+	} else {
+		ddsHeader.Flags |= DDSD_PITCH
+		ddsHeader.PitchOrLinearSize = uint32(levels[0].Size / levels[0].Height)
+	}
+	if len(levels) > 1 {
+		ddsHeader.Flags |= DDSD_MIPMAPCOUNT
+		ddsHeader.MipMapCount = uint32(len(levels))
+		ddsHeader.Caps |= DDSCAPS_COMPLEX | DDSCAPS_MIPMAP
+	}
+	if faces == 6 {
+		ddsHeader.Caps |= DDSCAPS_COMPLEX
+		ddsHeader.Caps2 = DDSCAPS2_CUBEMAP | DDSCAPS2_CUBEMAP_ALLFACES
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(DDS_MAGIC)
+	if err := binary.Write(&buf, binary.LittleEndian, ddsHeader); err != nil {
+		return err
+	}
+	buf.Write(data[:size])
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// isDDS checks whether data starts with the magic of a DDS file
+func isDDS(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(DDS_MAGIC))
+}
+
+// ddsPixelType returns the legacy PVR pixel type matching a DDS pixel format
+func ddsPixelType(pixelFormat DDSPixelFormat) (uint32, bool) {
+	for _, f := range textureFormats {
+		if f.isCompressed() {
+			if pixelFormat.Flags&DDPF_FOURCC != 0 && f.ddsFourCC == string(pixelFormat.FourCC[:]) {
+				return f.pixelType, true
+			}
+			continue
+		} else if pixelFormat.Flags&DDPF_FOURCC != 0 {
+			continue
+		}
+
+		masks := pvrDefaultBitMasks[f.pixelType]
+		g, b := pixelFormat.GBitMask, pixelFormat.BBitMask
+		if pixelFormat.Flags&DDPF_LUMINANCE != 0 {
+			g, b = pixelFormat.RBitMask, pixelFormat.RBitMask
+		}
+		a := uint32(0)
+		if pixelFormat.Flags&(DDPF_ALPHAPIXELS|DDPF_ALPHA) != 0 {
+			a = pixelFormat.ABitMask
+		}
+
+		if masks == (pvrBitMasks{int(pixelFormat.RGBBitCount), pixelFormat.RBitMask, g, b, a}) {
+			return f.pixelType, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeDDS(r *mtxReader) (PVRTC2Header, []byte, error) {
+	if err := r.need("DDS header", int64(len(DDS_MAGIC)+DDS_HEADER_SIZE)); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+	r.Seek(int64(len(DDS_MAGIC)), io.SeekCurrent)
+
+	ddsHeader := DDSHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &ddsHeader); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	if ddsHeader.Size != DDS_HEADER_SIZE {
+		return PVRTC2Header{}, nil, r.errorAt(4, "DDS header size", fmt.Errorf("%d is unsupported", ddsHeader.Size))
+	} else if ddsHeader.PixelFormat.Flags&DDPF_FOURCC != 0 && string(ddsHeader.PixelFormat.FourCC[:]) == "DX10" {
+		return PVRTC2Header{}, nil, r.errorAt(84, "DDS FourCC", errors.New("DX10 headers are unsupported"))
+	} else if err := r.limits.CheckImage(int(ddsHeader.Width), int(ddsHeader.Height)); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(12, "DDS dimensions", err)
+	}
+
+	pixelType, ok := ddsPixelType(ddsHeader.PixelFormat)
+	if !ok {
+		return PVRTC2Header{}, nil, r.errorAt(76, "DDS pixel format", errors.New("no legacy PVR pixel type matches it"))
+	}
+
+	levels, faces := 1, 1
+	if ddsHeader.Flags&DDSD_MIPMAPCOUNT != 0 {
+		levels = int(ddsHeader.MipMapCount)
+	}
+	if ddsHeader.Caps2&DDSCAPS2_CUBEMAP != 0 {
+		if ddsHeader.Caps2&DDSCAPS2_CUBEMAP_ALLFACES != DDSCAPS2_CUBEMAP_ALLFACES {
+			return PVRTC2Header{}, nil, r.errorAt(112, "DDS caps", errors.New("cube maps with missing faces are unsupported"))
+		}
+		faces = 6
+	}
+	if err := checkTextureLayout(int(ddsHeader.Width), int(ddsHeader.Height), levels, 1, faces, r.Len(), r.limits); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(28, "DDS layout", err)
+	}
+
+	header, err := newLegacyPVRHeader(pixelType, int(ddsHeader.Width), int(ddsHeader.Height), levels, 1, faces)
+	if err != nil {
+		return header, nil, err
+	}
+
+	if ddsHeader.PixelFormat.Flags&DDPF_FOURCC != 0 && ddsHeader.PixelFormat.Flags&DDPF_ALPHAPIXELS != 0 {
+		header.PixelFormatFlags |= PVR_FLAG_ALPHA
+	}
+
+	data, err := r.readField("DDS data", int64(header.CompressedDataSize))
+	return header, data, err
+}
@@ -0,0 +1,44 @@
+package mtx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors wrapped by ParseError. Their messages are phrased to follow the location of the problem
+var (
+	ErrUnsupportedVersion = errors.New("is unsupported")
+	ErrTruncated          = errors.New("exceeds file size")
+	ErrDimensionMismatch  = errors.New("doesn't match the image dimensions")
+	ErrBadPVRMagic        = errors.New("is unknown")
+	ErrMaskSizeMismatch   = errors.New("doesn't match the block dimensions")
+
+	// ErrLimitExceeded is matched by every *LimitError
+	ErrLimitExceeded = errors.New("exceeds a limit")
+)
+
+// ParseError describes a problem found while parsing an MTX or PVR file
+type ParseError struct {
+	Offset int64  // byte offset of the offending field
+	Block  int    // 1-based index of the block containing the field, or 0 for file-level fields
+	Field  string // name of the offending field
+	Err    error  // one of the sentinel errors above or an error returned by a decoder
+}
+
+func (e *ParseError) Error() string {
+	location := fmt.Sprintf("%s at offset 0x%X", e.Field, e.Offset)
+	if e.Block > 0 {
+		location = fmt.Sprintf("block %d %s", e.Block, location)
+	}
+
+	switch e.Err {
+	case ErrUnsupportedVersion, ErrTruncated, ErrDimensionMismatch, ErrBadPVRMagic, ErrMaskSizeMismatch:
+		return fmt.Sprintf("%s %v", location, e.Err)
+	default:
+		return fmt.Sprintf("%s: %v", location, e.Err)
+	}
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,141 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+)
+
+/*
+ETC1 stores opaque textures in 64-bit blocks of 4x4 pixels, stored row by row with their bytes in big-endian order.
+Each block is split into two halves of 2x4 or 4x2 pixels, depending on its flip bit.
+Every half has a base color and a table of four intensity modifiers, one of which is added to
+all channels of the base color for each pixel. The base colors are stored with 4 bits per channel
+each, or, in differential mode, as a color with 5 bits per channel and a 3-bit signed offset to it
+*/
+
+// etc1Modifiers holds the intensity modifier tables. Each pixel's 2-bit index selects +a, +b, -a or -b of its table
+var etc1Modifiers = [8][2]int{
+	{2, 8}, {5, 17}, {9, 29}, {13, 42}, {18, 60}, {24, 80}, {33, 106}, {47, 183},
+}
+
+// etc1Block is a single ETC1 block
+type etc1Block uint64
+
+// differential returns the block's differential mode bit
+func (b etc1Block) differential() bool {
+	return b>>33&1 != 0
+}
+
+// flipped returns the block's flip bit. Flipped blocks are split into a top and a bottom half instead of a left and a right one
+func (b etc1Block) flipped() bool {
+	return b>>32&1 != 0
+}
+
+// baseColors returns the base colors of both halves of the block
+func (b etc1Block) baseColors() [2]pvrtcColor {
+	var colors [2]pvrtcColor
+	for c := 0; c < 3; c++ {
+		shift := 59 - 8*c
+		if b.differential() {
+			base, delta := int(b>>shift&0x1F), int(b>>(shift-3)&7)
+			if delta >= 4 {
+				// the offset is a signed 3-bit number
+				delta -= 8
+			}
+			*colorChannel(&colors[0], c) = expandBits(base, 5)
+			*colorChannel(&colors[1], c) = expandBits((base+delta)&0x1F, 5)
+		} else {
+			*colorChannel(&colors[0], c) = expandBits(int(b>>(shift+1)&0xF), 4)
+			*colorChannel(&colors[1], c) = expandBits(int(b>>(shift-3)&0xF), 4)
+		}
+	}
+	colors[0].a, colors[1].a = 0xFF, 0xFF
+
+	return colors
+}
+
+// colorChannel returns a pointer to the red, green or blue channel of c
+func colorChannel(c *pvrtcColor, channel int) *int {
+	switch channel {
+	case 0:
+		return &c.r
+	case 1:
+		return &c.g
+	default:
+		return &c.b
+	}
+}
+
+// half returns which half of the block the pixel at x, y belongs to
+func (b etc1Block) half(x int, y int) int {
+	if b.flipped() {
+		return y / 2
+	}
+
+	return x / 2
+}
+
+// modifierIndex returns the 2-bit index of the pixel at x, y. Pixels are numbered column by column
+func (b etc1Block) modifierIndex(x int, y int) int {
+	i := x*4 + y
+	return int(b>>(16+i)&1)<<1 | int(b>>i&1)
+}
+
+// modifier returns the intensity modifier of the pixel at x, y
+func (b etc1Block) modifier(x int, y int) int {
+	table := etc1Modifiers[b>>(37-3*b.half(x, y))&7]
+	index := b.modifierIndex(x, y)
+
+	modifier := table[index&1]
+	if index&2 != 0 {
+		modifier = -modifier
+	}
+
+	return modifier
+}
+
+// etc1DataSize returns the number of bytes an ETC1 surface of the given size takes up
+func etc1DataSize(width int, height int) int {
+	return ceilDiv(width, 4) * ceilDiv(height, 4) * 8
+}
+
+// clampByte clamps v to the range of a byte
+func clampByte(v int) int {
+	if v < 0 {
+		return 0
+	} else if v > 0xFF {
+		return 0xFF
+	}
+
+	return v
+}
+
+// decodeETC1 decodes an ETC1 surface of the given size
+func decodeETC1(data []byte, width int, height int) (*image.NRGBA, error) {
+	if len(data) < etc1DataSize(width, height) {
+		return nil, fmt.Errorf("ETC1 data is too short for a %dx%d texture", width, height)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	blocksX := ceilDiv(width, 4)
+	for by := 0; by < ceilDiv(height, 4); by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			block := etc1Block(binary.BigEndian.Uint64(data[(by*blocksX+bx)*8:]))
+			colors := block.baseColors()
+
+			for y := 0; y < 4 && by*4+y < height; y++ {
+				for x := 0; x < 4 && bx*4+x < width; x++ {
+					base, modifier := colors[block.half(x, y)], block.modifier(x, y)
+					i := img.PixOffset(bx*4+x, by*4+y)
+					img.Pix[i] = uint8(clampByte(base.r + modifier))
+					img.Pix[i+1] = uint8(clampByte(base.g + modifier))
+					img.Pix[i+2] = uint8(clampByte(base.b + modifier))
+					img.Pix[i+3] = 0xFF
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,133 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// etc1Candidate is a possible encoding of a block, along with the squared error it causes
+type etc1Candidate struct {
+	block etc1Block
+	error int
+}
+
+// blockPixels returns the 4x4 pixels of the block at bx, by, numbered column by column like ETC1 modifier indices.
+// Pixels outside img repeat its right and bottom edges
+func blockPixels(img *image.NRGBA, bx int, by int) [16]pvrtcColor {
+	var pixels [16]pvrtcColor
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			px, py := bx*4+x, by*4+y
+			if px >= img.Rect.Dx() {
+				px = img.Rect.Dx() - 1
+			}
+			if py >= img.Rect.Dy() {
+				py = img.Rect.Dy() - 1
+			}
+
+			i := img.PixOffset(img.Rect.Min.X+px, img.Rect.Min.Y+py)
+			pixels[x*4+y] = pvrtcColor{int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2]), 0xFF}
+		}
+	}
+
+	return pixels
+}
+
+// encodeETC1Halves encodes pixels with the given flip bit and mode. The base colors are derived from
+// the average colors of both halves and each half then uses the modifier table that fits its pixels best
+func encodeETC1Halves(pixels [16]pvrtcColor, flipped bool, differential bool) etc1Candidate {
+	block := etc1Block(0)
+	if flipped {
+		block |= 1 << 32
+	}
+	if differential {
+		block |= 1 << 33
+	}
+
+	var sums [2]pvrtcVector
+	for i, p := range pixels {
+		half := block.half(i/4, i%4)
+		sums[half][0] += float64(p.r) / 8
+		sums[half][1] += float64(p.g) / 8
+		sums[half][2] += float64(p.b) / 8
+	}
+
+	for c := 0; c < 3; c++ {
+		shift := 59 - 8*c
+		if differential {
+			base, other := int(quantize(sums[0][c], 5)), int(quantize(sums[1][c], 5))
+			delta := other - base
+			if delta < -4 {
+				delta = -4
+			} else if delta > 3 {
+				delta = 3
+			}
+
+			block |= etc1Block(base)<<shift | etc1Block(delta&7)<<(shift-3)
+		} else {
+			block |= etc1Block(quantize(sums[0][c], 4))<<(shift+1) | etc1Block(quantize(sums[1][c], 4))<<(shift-3)
+		}
+	}
+
+	colors := block.baseColors()
+	totalError := 0
+	for half := 0; half < 2; half++ {
+		bestTable, bestError, bestIndices := 0, -1, etc1Block(0)
+		for table, modifiers := range etc1Modifiers {
+			tableError, indices := 0, etc1Block(0)
+			for i, p := range pixels {
+				if block.half(i/4, i%4) != half {
+					continue
+				}
+
+				bestIndex, bestPixelError := 0, -1
+				for index, modifier := range [4]int{modifiers[0], modifiers[1], -modifiers[0], -modifiers[1]} {
+					base := colors[half]
+					c := pvrtcColor{clampByte(base.r + modifier), clampByte(base.g + modifier), clampByte(base.b + modifier), 0xFF}
+					if e := colorError(c, p); bestPixelError < 0 || e < bestPixelError {
+						bestIndex, bestPixelError = index, e
+					}
+				}
+
+				tableError += bestPixelError
+				indices |= etc1Block(bestIndex>>1)<<(16+i) | etc1Block(bestIndex&1)<<i
+			}
+
+			if bestError < 0 || tableError < bestError {
+				bestTable, bestError, bestIndices = table, tableError, indices
+			}
+		}
+
+		block |= etc1Block(bestTable)<<(37-3*half) | bestIndices
+		totalError += bestError
+	}
+
+	return etc1Candidate{block, totalError}
+}
+
+// encodeETC1 compresses img into an ETC1 surface. Its alpha channel is ignored
+func encodeETC1(img *image.NRGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	blocksX := ceilDiv(width, 4)
+
+	data := make([]byte, etc1DataSize(width, height))
+	for by := 0; by < ceilDiv(height, 4); by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			pixels := blockPixels(img, bx, by)
+
+			// try both ways of splitting the block in both modes and keep the one closest to the original
+			best := etc1Candidate{error: -1}
+			for _, flipped := range []bool{false, true} {
+				for _, differential := range []bool{false, true} {
+					if c := encodeETC1Halves(pixels, flipped, differential); best.error < 0 || c.error < best.error {
+						best = c
+					}
+				}
+			}
+
+			binary.BigEndian.PutUint64(data[(by*blocksX+bx)*8:], uint64(best.block))
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,80 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// etc1Tests lists ETC1 blocks along with their pixels, row by row, as worked out from the format's specification
+var etc1Tests = []struct {
+	name   string
+	block  uint64
+	pixels [4][4]color.NRGBA
+}{
+	{
+		// base colors 0xF80 and 0x04F, modifier tables 0 and 7, modifier indices 0 to 3 from top to bottom
+		"individual", 0xF0840F1CCCCCAAAA, [4][4]color.NRGBA{
+			{{255, 138, 2, 255}, {255, 138, 2, 255}, {47, 115, 255, 255}, {47, 115, 255, 255}},
+			{{255, 144, 8, 255}, {255, 144, 8, 255}, {183, 251, 255, 255}, {183, 251, 255, 255}},
+			{{253, 134, 0, 255}, {253, 134, 0, 255}, {0, 21, 208, 255}, {0, 21, 208, 255}},
+			{{247, 128, 0, 255}, {247, 128, 0, 255}, {0, 0, 72, 255}, {0, 0, 72, 255}},
+		},
+	},
+	{
+		// base color 0x10/0x1F/0x00 with offsets +3/-4/+1, modifier tables 3 and 5, modifier indices 0 to 3 from left to right
+		"differential flipped", 0x83FC0177FF00F0F0, [4][4]color.NRGBA{
+			{{145, 255, 13, 255}, {174, 255, 42, 255}, {119, 242, 0, 255}, {90, 213, 0, 255}},
+			{{145, 255, 13, 255}, {174, 255, 42, 255}, {119, 242, 0, 255}, {90, 213, 0, 255}},
+			{{180, 246, 32, 255}, {236, 255, 88, 255}, {132, 198, 0, 255}, {76, 142, 0, 255}},
+			{{180, 246, 32, 255}, {236, 255, 88, 255}, {132, 198, 0, 255}, {76, 142, 0, 255}},
+		},
+	},
+}
+
+func TestDecodeETC1Blocks(t *testing.T) {
+	for _, test := range etc1Tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := make([]byte, 8)
+			binary.BigEndian.PutUint64(data, test.block)
+
+			img, err := decodeETC1(data, 4, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for y, row := range test.pixels {
+				for x, want := range row {
+					if got := img.NRGBAAt(x, y); got != want {
+						t.Errorf("pixel (%d, %d): got %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeETC1RoundTrip(t *testing.T) {
+	// ETC1 has no alpha channel, so the testcard is opaque like the textures it's used for.
+	// Its sides aren't multiples of 4, so the last blocks are partially outside the image
+	want := image.NewNRGBA(image.Rect(0, 0, 258, 130))
+	draw.Draw(want, want.Rect, readTestcard(t), image.Pt(352, 142), draw.Src)
+
+	data := encodeETC1(want)
+	if len(data) != etc1DataSize(258, 130) {
+		t.Fatalf("got %d bytes, want %d", len(data), etc1DataSize(258, 130))
+	}
+
+	img, err := decodeETC1(data, 258, 130)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := psnr(want, img)
+	t.Logf("PSNR: %.1f dB", result)
+	if result < 40 {
+		t.Errorf("got a PSNR of %.1f dB, want at least 40 dB", result)
+	}
+}
@@ -0,0 +1,254 @@
+package mtx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// File represents the contents of an MTX file
+type File struct {
+	Version uint32
+
+	// Header and HeaderV2 hold the file header as it was read by Decode. Encode keeps HeaderV2.Unknown,
+	// as its meaning is unknown, and Header's lengths as long as every tier's block keeps the length it was read with.
+	// The other fields are derived from the file's contents
+	Header   HeaderV0V1
+	HeaderV2 HeaderV2
+
+	// readBlockLengths holds the lengths of the tiers' blocks as they were read, which Header's lengths belong to
+	readBlockLengths []int64
+
+	// Tiers holds the quality tiers of MTXv0 and MTXv1 files, ordered from smallest to largest
+	Tiers []*Tier
+
+	// PVRHeader and PVRData hold the PVR texture wrapped by MTXv2 files
+	PVRHeader PVRTC2Header
+	PVRData   []byte
+
+	// Trailing holds any data found after the last block
+	Trailing []byte
+
+	// OddSize is the policy the smaller tiers were sized with. Like Tier.PassedThrough, it's only known while baking
+	OddSize OddSizePolicy
+}
+
+// Tier represents a single quality tier of an MTXv0 or MTXv1 file
+type Tier struct {
+	Width  int
+	Height int
+
+	// Color holds the tier's decoded color image
+	Color image.Image
+	// Mask holds the tier's alpha mask. It's nil for MTXv0 tiers
+	Mask *image.Gray
+
+	// RawColor and RawMask hold the JPEG and zlib data as stored in the MTX file.
+	// Tiers with nil raw data are encoded from Color and Mask when the file is written
+	RawColor []byte
+	RawMask  []byte
+
+	// PassedThrough is set for tiers whose color data was taken from a JPEG input file as it was
+	PassedThrough bool
+
+	// JPEGQuality overrides BakeOptions.JPEGQuality for this tier if it's set
+	JPEGQuality int
+}
+
+// NewTier creates a tier from img. If withMask is set, img's alpha channel is used as the tier's mask
+func NewTier(img image.Image, withMask bool) *Tier {
+	tier := &Tier{}
+	tier.SetImage(img, withMask)
+	return tier
+}
+
+// SetImage replaces the tier's color image and, if withMask is set, its mask with img's alpha channel.
+// The tier's raw data is discarded so it will be re-encoded
+func (t *Tier) SetImage(img image.Image, withMask bool) {
+	t.Width = img.Bounds().Dx()
+	t.Height = img.Bounds().Dy()
+	t.Color = img
+	t.RawColor = nil
+	t.PassedThrough = false
+	t.Mask = nil
+	t.RawMask = nil
+
+	if withMask {
+		nrgba := imageToNRGBA(img)
+		t.Mask = newGrayFromRawData(getAlphaChannel(nrgba), t.Width, t.Height)
+	}
+}
+
+// SetMask replaces the tier's mask. Its raw data is discarded so it will be re-encoded
+func (t *Tier) SetMask(mask *image.Gray) error {
+	if mask.Bounds().Dx() != t.Width || mask.Bounds().Dy() != t.Height {
+		return errors.New("mask size doesn't match the tier's size")
+	}
+
+	t.Mask = mask
+	t.RawMask = nil
+	return nil
+}
+
+// Image returns the tier's color image with its mask applied
+func (t *Tier) Image() *image.NRGBA {
+	rgba := imageToNRGBA(t.Color)
+	if t.Mask == nil {
+		return rgba
+	}
+
+	// the mask's stride may differ from its width if it's a sub-image
+	for y := 0; y < t.Height; y++ {
+		maskRow := t.Mask.Pix[y*t.Mask.Stride : y*t.Mask.Stride+t.Width]
+		for x, alpha := range maskRow {
+			rgba.Pix[y*rgba.Stride+x*4+3] = alpha
+		}
+	}
+
+	return rgba
+}
+
+// NewFile creates an MTX file from img. For MTXv0 and MTXv1 files, smaller quality tiers are generated along the way,
+// each one half as wide and high as the next one, with odd sides handled according to opts.OddSize.
+// MTXv2 files wrap a texture in opts.PVRFormat. opts may be nil
+func NewFile(version uint32, img image.Image, opts *BakeOptions) (*File, error) {
+	if version > 2 {
+		return nil, fmt.Errorf("unsupported MTX version %d", version)
+	}
+
+	opts = opts.withDefaults()
+	if version == 2 {
+		if err := opts.Limits.CheckImage(img.Bounds().Dx(), img.Bounds().Dy()); err != nil {
+			return nil, err
+		}
+
+		header, data, err := EncodePVRImage(img, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewPVRFile(header, data), nil
+	}
+
+	filter, ok := resampleFilters[opts.Filter]
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter %q", opts.Filter)
+	}
+
+	if opts.Tiers < 1 {
+		return nil, fmt.Errorf("invalid tier count %d", opts.Tiers)
+	} else if err := opts.Limits.CheckTiers(opts.Tiers); err != nil {
+		return nil, err
+	} else if err := opts.Limits.CheckImage(img.Bounds().Dx(), img.Bounds().Dy()); err != nil {
+		return nil, err
+	} else if maxTiers := maxTierCount(img.Bounds().Dx(), img.Bounds().Dy()); opts.Tiers > maxTiers {
+		return nil, fmt.Errorf("a %dx%d image can be halved into at most %d tiers, not %d", img.Bounds().Dx(), img.Bounds().Dy(), maxTiers, opts.Tiers)
+	}
+
+	img, err := fitTiers(img, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	withMask := version == 1
+	tiers := make([]*Tier, opts.Tiers)
+	tiers[len(tiers)-1] = NewTier(img, withMask)
+
+	for i := len(tiers) - 2; i >= 0; i-- {
+		width, height := tierSize(img.Bounds().Dx(), img.Bounds().Dy(), len(tiers)-1-i, opts.OddSize)
+
+		resized := resizePremultiplied(img, width, height, filter, opts.LinearLight)
+		if opts.Sharpen > 0 {
+			resized = sharpenColors(resized, opts.Sharpen)
+		}
+		tiers[i] = NewTier(resized, withMask)
+		tiers[i].JPEGQuality = opts.SmallJPEGQuality
+	}
+
+	return &File{
+		Version: version,
+		Tiers:   tiers,
+		OddSize: opts.OddSize,
+	}, nil
+}
+
+// NewFileFromJPEG creates an MTXv0 or MTXv1 file from JPEG data like NewFile does.
+// Unless opts.ReencodeJPEG is set, baseline JPEG data is embedded as the largest tier as it is,
+// so it doesn't lose quality by being encoded again. Only the smaller tiers are encoded
+func NewFileFromJPEG(version uint32, data []byte, opts *BakeOptions) (*File, error) {
+	// check the image's dimensions before decoding it
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	} else if err := opts.withDefaults().Limits.CheckImage(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	mtxFile, err := NewFile(version, img, opts)
+	if err != nil || (opts != nil && opts.ReencodeJPEG) {
+		return mtxFile, err
+	}
+
+	// the games might not be able to decode anything other than baseline JPEGs
+	if marker, _ := jpegFrameMarker(data); marker != 0xC0 {
+		log.Info("Input is not a baseline JPEG file and will be re-encoded")
+		return mtxFile, nil
+	}
+
+	largest := mtxFile.Tiers[len(mtxFile.Tiers)-1]
+	if largest.Color == img {
+		largest.RawColor = data
+		largest.PassedThrough = true
+	}
+
+	return mtxFile, nil
+}
+
+// SetSmallTier replaces the smallest tier of an MTXv0 or MTXv1 file with img, for smaller images made by hand.
+// img needs to be half as wide and high as the next tier, rounded either way. Differences in transparency are warned about
+func (f *File) SetSmallTier(img image.Image) error {
+	if f.Version > 1 {
+		return errors.New("only MTXv0 and MTXv1 files contain smaller images")
+	} else if len(f.Tiers) < 2 {
+		return errors.New("the file doesn't contain a smaller image to replace")
+	}
+
+	next := f.Tiers[1]
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if (width != next.Width/2 && width != ceilDiv(next.Width, 2)) || (height != next.Height/2 && height != ceilDiv(next.Height, 2)) {
+		return fmt.Errorf("the smaller image is %dx%d, but needs to be half the size of the %dx%d image", width, height, next.Width, next.Height)
+	}
+
+	nrgba := imageToNRGBA(img)
+	if !isOpaque(nrgba) {
+		if f.Version == 0 {
+			log.Warn("The smaller image is transparent, but MTXv0 files have no masks, so its transparency will be lost")
+		} else if isOpaque(next.Image()) {
+			log.Warn("The smaller image is transparent, but the larger one is opaque")
+		}
+	} else if f.Version == 1 && !isOpaque(next.Image()) {
+		log.Warn("The smaller image is opaque, but the larger one is transparent")
+	}
+
+	f.Tiers[0].SetImage(nrgba, f.Version == 1)
+	return nil
+}
+
+// NewPVRFile creates an MTXv2 file wrapping a PVR texture. Its header's unknown field is set to DEFAULT_V2_UNKNOWN
+func NewPVRFile(header PVRTC2Header, data []byte) *File {
+	return &File{
+		Version:   2,
+		HeaderV2:  HeaderV2{Magic: 2, Unknown: DEFAULT_V2_UNKNOWN},
+		PVRHeader: header,
+		PVRData:   data,
+	}
+}
@@ -0,0 +1,105 @@
+package mtx
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// testJPEG encodes a part of the test image of the given size as a baseline JPEG file
+func testJPEG(t *testing.T, width int, height int) []byte {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, testImage().SubImage(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNewFileFromJPEGPassesThrough(t *testing.T) {
+	data := testJPEG(t, 64, 32)
+
+	for version := uint32(0); version <= 1; version++ {
+		f, err := NewFileFromJPEG(version, data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		largest := f.Tiers[len(f.Tiers)-1]
+		if !largest.PassedThrough || !bytes.Equal(largest.RawColor, data) {
+			t.Errorf("MTXv%d: the JPEG file wasn't passed through", version)
+		}
+
+		// the encoded file holds the input file as it is, too
+		buf := bytes.Buffer{}
+		if err := Encode(&buf, f, nil); err != nil {
+			t.Fatal(err)
+		}
+		f, err = Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(f.Tiers[len(f.Tiers)-1].RawColor, data) {
+			t.Errorf("MTXv%d: the encoded file doesn't hold the JPEG file", version)
+		}
+	}
+}
+
+func TestNewFileFromJPEGReencodes(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		opts          *BakeOptions
+	}{
+		// padding changes the larger image, so it can't be passed through
+		{"padded", 63, 33, &BakeOptions{OddSize: OddSizePad}},
+		{"reencode set", 64, 32, &BakeOptions{ReencodeJPEG: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := testJPEG(t, test.width, test.height)
+			f, err := NewFileFromJPEG(1, data, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			largest := f.Tiers[len(f.Tiers)-1]
+			if largest.PassedThrough || bytes.Equal(largest.RawColor, data) {
+				t.Error("the JPEG file was passed through")
+			}
+		})
+	}
+}
+
+func TestSetSmallTier(t *testing.T) {
+	for version := uint32(0); version <= 1; version++ {
+		// 63x33 tiers accept 31x16 or 32x17 smaller images
+		f, err := NewFile(version, image.NewNRGBA(image.Rect(0, 0, 63, 33)), &BakeOptions{OddSize: OddSizeRoundUp})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, size := range []image.Point{{31, 16}, {32, 17}} {
+			// the larger tier is transparent black, so a red image tells whether the smaller one was replaced
+			small := image.NewNRGBA(image.Rectangle{Max: size})
+			draw.Draw(small, small.Bounds(), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+			if err := f.SetSmallTier(small); err != nil {
+				t.Fatalf("MTXv%d, %dx%d: %v", version, size.X, size.Y, err)
+			}
+
+			tier := f.Tiers[0]
+			if r, _, _, _ := tier.Image().At(size.X/2, size.Y/2).RGBA(); tier.Width != size.X || tier.Height != size.Y || r < 0xF000 {
+				t.Errorf("MTXv%d, %dx%d: the smaller tier wasn't replaced", version, size.X, size.Y)
+			}
+		}
+
+		for _, size := range []image.Point{{30, 16}, {32, 18}, {63, 33}} {
+			if err := f.SetSmallTier(image.NewNRGBA(image.Rectangle{Max: size})); err == nil {
+				t.Errorf("MTXv%d, %dx%d: got no error", version, size.X, size.Y)
+			}
+		}
+	}
+}
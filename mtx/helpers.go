@@ -3,16 +3,16 @@ package mtx
 import (
 	"bytes"
 	"compress/zlib"
+	"encoding/binary"
 	"image"
 	"image/draw"
 	"io"
-	"os"
 )
 
-func readSomeBytes(file *os.File, number int) ([]byte, error) {
+func readSomeBytes(r io.Reader, number int) ([]byte, error) {
 	b := make([]byte, number)
 
-	_, err := file.Read(b)
+	_, err := io.ReadFull(r, b)
 	if err != nil {
 		return nil, err
 	}
@@ -20,7 +20,71 @@ func readSomeBytes(file *os.File, number int) ([]byte, error) {
 	return b, nil
 }
 
-func decompressZlibData(data []byte) ([]byte, error) {
+// mtxReader reads the contents of an MTX or PVR file and keeps track of the block being read,
+// so problems can be reported as ParseErrors pointing to their location
+type mtxReader struct {
+	*bytes.Reader
+	block  int
+	limits *Limits
+}
+
+func newMTXReader(data []byte, limits *Limits) *mtxReader {
+	return &mtxReader{Reader: bytes.NewReader(data), limits: limits.withDefaults()}
+}
+
+// offset returns the position of the next byte to be read
+func (r *mtxReader) offset() int64 {
+	return r.Size() - int64(r.Len())
+}
+
+func (r *mtxReader) errorAt(offset int64, field string, err error) error {
+	return &ParseError{
+		Offset: offset,
+		Block:  r.block,
+		Field:  field,
+		Err:    err,
+	}
+}
+
+// need returns an ErrTruncated ParseError for field if fewer than n bytes are left
+func (r *mtxReader) need(field string, n int64) error {
+	if n > int64(r.Len()) {
+		return r.errorAt(r.offset(), field, ErrTruncated)
+	}
+
+	return nil
+}
+
+// readField reads the n bytes making up field
+func (r *mtxReader) readField(field string, n int64) ([]byte, error) {
+	if err := r.need(field, n); err != nil {
+		return nil, err
+	}
+
+	return readSomeBytes(r, int(n))
+}
+
+// readChunk reads a chunk of data preceded by its length as a uint32
+func (r *mtxReader) readChunk(field string) ([]byte, error) {
+	lengthField := field + " length"
+	lengthOffset := r.offset()
+
+	b, err := r.readField(lengthField, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkLength := int64(binary.LittleEndian.Uint32(b))
+	if chunkLength > int64(r.Len()) {
+		return nil, r.errorAt(lengthOffset, lengthField, ErrTruncated)
+	}
+
+	return readSomeBytes(r, int(chunkLength))
+}
+
+// decompressZlibData decompresses data, failing once more than maxSize bytes have been decompressed.
+// A negative maxSize disables the check
+func decompressZlibData(data []byte, maxSize int) ([]byte, error) {
 	b := bytes.NewReader(data)
 	z, err := zlib.NewReader(b)
 	if err != nil {
@@ -28,17 +92,25 @@ func decompressZlibData(data []byte) ([]byte, error) {
 	}
 	defer z.Close()
 
-	decompBytes, err := io.ReadAll(z)
+	var src io.Reader = z
+	if maxSize >= 0 {
+		// read one byte more than allowed so oversized data can be detected
+		src = io.LimitReader(z, int64(maxSize)+1)
+	}
+
+	decompBytes, err := io.ReadAll(src)
 	if err != nil {
 		return nil, err
+	} else if err := check("decompressed mask size", int64(len(decompBytes)), int64(maxSize)); err != nil {
+		return nil, err
 	}
 
 	return decompBytes, nil
 }
 
-func compressZlibData(data []byte) ([]byte, error) {
+func compressZlibData(data []byte, level int) ([]byte, error) {
 	var b bytes.Buffer
-	z, err := zlib.NewWriterLevel(&b, zlib.BestCompression)
+	z, err := zlib.NewWriterLevel(&b, level)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +152,17 @@ func getAlphaChannel(img *image.NRGBA) []byte {
 	return alpha
 }
 
+// isOpaque checks whether all of img's pixels are fully opaque
+func isOpaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xFF {
+			return false
+		}
+	}
+
+	return true
+}
+
 func makeAlphaChannelOpaque(rgba *image.NRGBA) {
 	capacity := len(rgba.Pix) / 4
 
@@ -88,3 +171,71 @@ func makeAlphaChannelOpaque(rgba *image.NRGBA) {
 		rgba.Pix[alphaIdx] = 0xFF
 	}
 }
+
+// atLeast returns the larger of value and minimum
+func atLeast(value int, minimum int) int {
+	if value < minimum {
+		return minimum
+	}
+
+	return value
+}
+
+// ceilDiv divides a by b, rounding up
+func ceilDiv(a int, b int) int {
+	return (a + b - 1) / b
+}
+
+// jpegFrameMarker returns the start-of-frame marker of JPEG data, e.g. 0xC0 for baseline and 0xC2 for progressive JPEGs
+func jpegFrameMarker(data []byte) (byte, bool) {
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return 0, false
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0, false
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			i++
+			continue
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			// SOFn markers, excluding DHT, JPG and DAC
+			return marker, true
+		case marker == 0xD9 || marker == 0xDA:
+			// reached EOI or SOS without finding a frame header
+			return 0, false
+		}
+
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+
+	return 0, false
+}
+
+// padImage enlarges img to width x height by repeating its right and bottom edges
+func padImage(img *image.NRGBA, width int, height int) *image.NRGBA {
+	padded := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		srcY := y
+		if srcY >= img.Rect.Dy() {
+			srcY = img.Rect.Dy() - 1
+		}
+
+		for x := 0; x < width; x++ {
+			srcX := x
+			if srcX >= img.Rect.Dx() {
+				srcX = img.Rect.Dx() - 1
+			}
+
+			src := img.PixOffset(img.Rect.Min.X+srcX, img.Rect.Min.Y+srcY)
+			copy(padded.Pix[padded.PixOffset(x, y):], img.Pix[src:src+4])
+		}
+	}
+
+	return padded
+}
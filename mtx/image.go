@@ -0,0 +1,168 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+/*
+MTX's magic numbers are just 0, 1 and 2, so matching on them alone would claim lots of unrelated files.
+The patterns below additionally require a JPEG SOI marker where the first color data is expected to start,
+or the PVR magic in the case of MTXv2 files.
+Before decoding, the header lengths are checked against the positions of the remaining SOI markers as well
+*/
+const (
+	jpegSOI = "\xff\xd8"
+
+	mtxV0Magic = "\x00\x00\x00\x00????????" + jpegSOI
+	mtxV1Magic = "\x01\x00\x00\x00????????\x01\x00\x00\x00????????????" + jpegSOI
+	mtxV2Magic = "\x02\x00\x00\x00??????????????????????????????????????????????PVR!"
+)
+
+func init() {
+	image.RegisterFormat("mtx", mtxV0Magic, decodeImage, decodeImageConfig)
+	image.RegisterFormat("mtx", mtxV1Magic, decodeImage, decodeImageConfig)
+	image.RegisterFormat("mtx", mtxV2Magic, decodeImage, decodeImageConfig)
+}
+
+// sniffMTX checks that data is an MTXv0 or MTXv1 file whose header lengths line up with its JPEG data.
+// MTXv2 files are recognized by their PVR magic alone
+func sniffMTX(data []byte) error {
+	if isMTXv2(data) {
+		return nil
+	}
+
+	r := bytes.NewReader(data)
+	header, err := readHeaderV0V1(r)
+	if err != nil {
+		return err
+	}
+
+	if int64(header.LengthFirst)+int64(header.LengthSecond) > int64(r.Len()) {
+		return errors.New("mtx: header lengths exceed file size")
+	}
+
+	offset := int64(HEADER_V0V1_SIZE)
+	for _, length := range []uint32{header.LengthFirst, header.LengthSecond} {
+		if length == 0 {
+			continue
+		}
+
+		// MTXv1 blocks start with a block header and the color data length
+		colorOffset := offset
+		if header.Magic == 1 {
+			colorOffset += BLOCK_HEADER_V1_SIZE + 4
+		}
+
+		if colorOffset+2 > int64(len(data)) || string(data[colorOffset:colorOffset+2]) != jpegSOI {
+			return errors.New("mtx: header lengths don't match JPEG data")
+		}
+
+		offset += int64(length)
+	}
+
+	return nil
+}
+
+// isMTXv2 checks whether data starts like an MTXv2 file
+func isMTXv2(data []byte) bool {
+	return len(data) >= HEADER_V2_SIZE+PVRTC2_HEADER_SIZE && binary.LittleEndian.Uint32(data) == 2
+}
+
+// decodeImage decodes the largest tier of an MTXv0 or MTXv1 file with its mask applied,
+// or the texture of an MTXv2 file, using the default limits
+func decodeImage(r io.Reader) (image.Image, error) {
+	data, err := readInput(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sniffMTX(data); err != nil {
+		return nil, err
+	}
+
+	mtxFile, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	} else if mtxFile.Version == 2 {
+		return DecodePVRImage(mtxFile.PVRHeader, mtxFile.PVRData)
+	}
+
+	tier := mtxFile.largestTier()
+	if tier == nil {
+		return nil, errors.New("mtx: file contains no images")
+	}
+
+	return tier.Image(), nil
+}
+
+// decodeImageConfig returns the dimensions of the largest tier of an MTXv0 or MTXv1 file, or of an MTXv2 file's texture, without decoding any images
+func decodeImageConfig(r io.Reader) (image.Config, error) {
+	data, err := readInput(r, nil)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	if err := sniffMTX(data); err != nil {
+		return image.Config{}, err
+	}
+
+	if isMTXv2(data) {
+		pvrHeader, err := readPVRTC2Header(bytes.NewReader(data[HEADER_V2_SIZE:]))
+		if err != nil {
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: color.NRGBAModel, Width: int(pvrHeader.Width), Height: int(pvrHeader.Height)}, nil
+	}
+
+	header, _ := readHeaderV0V1(bytes.NewReader(data))
+
+	config := image.Config{ColorModel: color.NRGBAModel}
+	offset := int64(HEADER_V0V1_SIZE)
+	for _, length := range []uint32{header.LengthFirst, header.LengthSecond} {
+		if length == 0 {
+			continue
+		}
+
+		block := data[offset : offset+int64(length)]
+		offset += int64(length)
+
+		var width, height int
+		if header.Magic == 1 {
+			blockHeader, err := readBlockHeaderV1(bytes.NewReader(block))
+			if err != nil {
+				return image.Config{}, err
+			}
+			width, height = int(blockHeader.Width), int(blockHeader.Height)
+		} else {
+			jpegConfig, err := jpeg.DecodeConfig(bytes.NewReader(block))
+			if err != nil {
+				return image.Config{}, err
+			}
+			width, height = jpegConfig.Width, jpegConfig.Height
+		}
+
+		if width*height > config.Width*config.Height {
+			config.Width, config.Height = width, height
+		}
+	}
+
+	return config, nil
+}
+
+// largestTier returns the tier with the most pixels, or nil if the file has no tiers
+func (f *File) largestTier() *Tier {
+	var largest *Tier
+	for _, tier := range f.Tiers {
+		if largest == nil || tier.Width*tier.Height > largest.Width*largest.Height {
+			largest = tier
+		}
+	}
+
+	return largest
+}
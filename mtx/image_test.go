@@ -0,0 +1,61 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestImageDecode(t *testing.T) {
+	for version := uint32(0); version <= 2; version++ {
+		data, err := encodeTestImage(version, testImage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("MTXv%d config: %v", version, err)
+		} else if format != "mtx" || config.Width != 64 || config.Height != 64 {
+			t.Errorf("MTXv%d config: got a %dx%d %s image, want a 64x64 mtx image", version, config.Width, config.Height, format)
+		}
+
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("MTXv%d: %v", version, err)
+		} else if format != "mtx" || img.Bounds() != image.Rect(0, 0, 64, 64) {
+			t.Errorf("MTXv%d: got a %v %s image, want a 64x64 mtx image", version, img.Bounds(), format)
+		}
+	}
+}
+
+func TestImageDecodeRejectsInconsistentLengths(t *testing.T) {
+	// the files still start like MTX files, but their header lengths don't fit the data
+	tests := []struct {
+		name                    string
+		deltaFirst, deltaSecond int
+	}{
+		{"beyond the end", 0, 1},
+		{"misplaced larger tier", 1, -1},
+	}
+
+	for version := uint32(0); version <= 1; version++ {
+		for _, test := range tests {
+			data, err := encodeTestImage(version, testImage(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			binary.LittleEndian.PutUint32(data[4:], uint32(int(binary.LittleEndian.Uint32(data[4:]))+test.deltaFirst))
+			binary.LittleEndian.PutUint32(data[8:], uint32(int(binary.LittleEndian.Uint32(data[8:]))+test.deltaSecond))
+
+			if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				t.Errorf("MTXv%d %s config: got no error", version, test.name)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				t.Errorf("MTXv%d %s: got no error", version, test.name)
+			}
+		}
+	}
+}
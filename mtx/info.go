@@ -0,0 +1,114 @@
+package mtx
+
+import (
+	"bytes"
+	"image/jpeg"
+)
+
+// Info describes the headers and layout of a decoded MTX file
+type Info struct {
+	Version  uint32      `json:"version"`
+	Header   *HeaderV0V1 `json:"header,omitempty"`
+	HeaderV2 *HeaderV2   `json:"headerV2,omitempty"`
+
+	Tiers []TierInfo `json:"tiers,omitempty"`
+	// OddSize is the policy the smaller tiers were sized with. For files read from disk, it's derived from their dimensions,
+	// which can only tell OddSizeRoundDown and OddSizeRoundUp apart and are OddSizeUnknown if the sides halve exactly
+	OddSize OddSizePolicy `json:"oddSize,omitempty"`
+
+	PVR *PVRInfo `json:"pvr,omitempty"`
+
+	TrailingBytes int `json:"trailingBytes"`
+}
+
+// TierInfo describes a single quality tier of an MTXv0 or MTXv1 file
+type TierInfo struct {
+	Offset int `json:"offset"` // offset of the tier's block
+	Size   int `json:"size"`   // size of the tier's block, including block headers and chunk lengths
+
+	Width  int `json:"width"`  // width stored in the block header, or the JPEG's width for MTXv0
+	Height int `json:"height"` // ditto, but for the height
+
+	JPEGSize   int `json:"jpegSize"`
+	JPEGWidth  int `json:"jpegWidth"`
+	JPEGHeight int `json:"jpegHeight"`
+
+	// PassedThrough is set for tiers whose JPEG data was taken from the input file as it was.
+	// This is only known while baking, not for files read from disk
+	PassedThrough bool `json:"passedThrough,omitempty"`
+
+	MaskSize             int `json:"maskSize,omitempty"`
+	MaskDecompressedSize int `json:"maskDecompressedSize,omitempty"`
+}
+
+// PVRInfo describes the PVR texture wrapped by an MTXv2 file
+type PVRInfo struct {
+	Header    PVRTC2Header `json:"header"`
+	PixelType string       `json:"pixelType"`
+	Flags     []string     `json:"flags"`
+	DataSize  int          `json:"dataSize"`
+
+	// Levels holds the layout of every mip level of every surface. It's empty for pixel types whose size is unknown
+	Levels []PVRLevel `json:"levels,omitempty"`
+}
+
+// Info returns a description of the file's headers and layout
+func (f *File) Info() *Info {
+	info := &Info{
+		Version:       f.Version,
+		TrailingBytes: len(f.Trailing),
+	}
+
+	switch f.Version {
+	case 0, 1:
+		header := f.Header
+		info.Header = &header
+		info.Tiers = make([]TierInfo, len(f.Tiers))
+		info.OddSize = f.OddSize
+		if info.OddSize == "" {
+			info.OddSize = f.detectOddSize()
+		}
+
+		offset := HEADER_V0V1_SIZE
+		for i, tier := range f.Tiers {
+			tierInfo := TierInfo{
+				Offset:   offset,
+				Width:    tier.Width,
+				Height:   tier.Height,
+				JPEGSize: len(tier.RawColor),
+				MaskSize: len(tier.RawMask),
+
+				PassedThrough: tier.PassedThrough,
+			}
+
+			if config, err := jpeg.DecodeConfig(bytes.NewReader(tier.RawColor)); err == nil {
+				tierInfo.JPEGWidth = config.Width
+				tierInfo.JPEGHeight = config.Height
+			}
+
+			if f.Version == 1 {
+				tierInfo.Size = BLOCK_HEADER_V1_SIZE + 8 + tierInfo.JPEGSize + tierInfo.MaskSize
+				if tier.Mask != nil {
+					tierInfo.MaskDecompressedSize = len(tier.Mask.Pix)
+				}
+			} else {
+				tierInfo.Size = tierInfo.JPEGSize
+			}
+
+			offset += tierInfo.Size
+			info.Tiers[i] = tierInfo
+		}
+	case 2:
+		header := f.HeaderV2
+		info.HeaderV2 = &header
+		info.PVR = &PVRInfo{
+			Header:    f.PVRHeader,
+			PixelType: f.PVRHeader.PixelTypeName(),
+			Flags:     f.PVRHeader.FlagNames(),
+			DataSize:  len(f.PVRData),
+		}
+		info.PVR.Levels, _ = f.PVRHeader.Levels()
+	}
+
+	return info
+}
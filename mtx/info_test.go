@@ -0,0 +1,47 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInfoGolden(t *testing.T) {
+	for _, name := range []string{"smashhitlp.png.mtx", "pinoutlogo.png.mtx", "testcard.pvr.mtx"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("..", "examples", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(f.Info(), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			// the info is compared as JSON, which covers every field along with its name
+			golden := filepath.Join("testdata", name+".info.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("got info\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,597 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+/*
+KTX files store every mip level of all array elements and cube map faces one after the other, like PVR v3 files.
+KTX 1 files identify pixel formats by their OpenGL identifiers and pad every row of uncompressed data
+to a multiple of 4 bytes. KTX 2 files use Vulkan identifiers, store no row padding and describe the
+pixel format once more in a data format descriptor
+*/
+
+const (
+	KTX_IDENTIFIER  = "\xABKTX 11\xBB\r\n\x1A\n"
+	KTX2_IDENTIFIER = "\xABKTX 20\xBB\r\n\x1A\n"
+)
+
+const (
+	KTX_ENDIANNESS      = 0x04030201
+	KTX_ORIENTATION_KEY = "KTXorientation"
+	KTX_WRITER_KEY      = "KTXwriter"
+	KTX_WRITER          = "mtxconv"
+
+	// data format descriptor values used by KTX 2 files
+	KHR_DF_MODEL_RGBSDA        = 1
+	KHR_DF_MODEL_ETC1          = 160
+	KHR_DF_MODEL_PVRTC         = 164
+	KHR_DF_MODEL_PVRTC2        = 165
+	KHR_DF_PRIMARIES_BT709     = 1
+	KHR_DF_TRANSFER_LINEAR     = 1
+	KHR_DF_CHANNEL_RGBSDA_R    = 0
+	KHR_DF_CHANNEL_RGBSDA_G    = 1
+	KHR_DF_CHANNEL_RGBSDA_B    = 2
+	KHR_DF_CHANNEL_RGBSDA_A    = 15
+	KHR_DF_CHANNEL_ALPHA       = 15 // compressed models like BC1A mark textures with alpha by a sample of channel 15
+	KHR_DF_CHANNEL_MASK        = 0x0F
+	KHR_DF_VERSION             = 2
+	KHR_DF_BASIC_BLOCK_SIZE    = 24
+	KHR_DF_SAMPLE_SIZE         = 16
+	KTX2_SUPERCOMPRESSION_NONE = 0
+)
+
+// KTXHeader is the header of a KTX 1 file, following its identifier
+type KTXHeader struct {
+	Endianness            uint32
+	GLType                uint32
+	GLTypeSize            uint32
+	GLFormat              uint32
+	GLInternalFormat      uint32
+	GLBaseInternalFormat  uint32
+	PixelWidth            uint32
+	PixelHeight           uint32
+	PixelDepth            uint32
+	NumberOfArrayElements uint32
+	NumberOfFaces         uint32
+	NumberOfMipmapLevels  uint32
+	BytesOfKeyValueData   uint32
+}
+
+// KTX2Header is the header of a KTX 2 file, following its identifier
+type KTX2Header struct {
+	VKFormat               uint32
+	TypeSize               uint32
+	PixelWidth             uint32
+	PixelHeight            uint32
+	PixelDepth             uint32
+	LayerCount             uint32
+	FaceCount              uint32
+	LevelCount             uint32
+	SupercompressionScheme uint32
+	DFDByteOffset          uint32
+	DFDByteLength          uint32
+	KVDByteOffset          uint32
+	KVDByteLength          uint32
+	SGDByteOffset          uint64
+	SGDByteLength          uint64
+}
+
+// ktx2LevelIndex locates a mip level within a KTX 2 file
+type ktx2LevelIndex struct {
+	ByteOffset             uint64
+	ByteLength             uint64
+	UncompressedByteLength uint64
+}
+
+// ktxLayout returns the number of array elements and faces of a legacy PVR texture
+func ktxLayout(h PVRTC2Header) (elements int, faces int) {
+	elements, faces = atLeast(int(h.NumSurfaces), 1), 1
+	if h.PixelFormatFlags&PVR_FLAG_CUBEMAP != 0 && elements%6 == 0 {
+		elements, faces = elements/6, 6
+	}
+
+	return elements, faces
+}
+
+// baseLevels returns the mip levels of the first surface of a legacy PVR texture.
+// available is the size of the texture's data, which needs to fit its mip level and surface counts
+func baseLevels(h PVRTC2Header, available int) ([]PVRLevel, error) {
+	if err := h.checkLayout(available); err != nil {
+		return nil, err
+	}
+	levels, ok := h.Levels()
+	if !ok {
+		return nil, fmt.Errorf("the size of %s textures is unknown", h.PixelTypeName())
+	}
+
+	return levels[:h.MipMapCount+1], nil
+}
+
+// ktxRowPadding returns the number of bytes a KTX 1 file adds to every row of an uncompressed level.
+// As every row ends aligned to 4 bytes, no further padding is needed between images
+func ktxRowPadding(h PVRTC2Header, width int) int {
+	masks, ok := h.bitMasks()
+	if !ok {
+		return 0
+	}
+
+	rowSize := width * masks.bitCount / 8
+	return ceilDiv(rowSize, 4)*4 - rowSize
+}
+
+// writeKeyValueData writes the key/value pairs of a KTX file, sorted by key and padded to a multiple of 4 bytes
+func writeKeyValueData(w *bytes.Buffer, pairs map[string]string) {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := key + "\x00" + pairs[key] + "\x00"
+		binary.Write(w, binary.LittleEndian, uint32(len(entry)))
+		w.WriteString(entry)
+		w.Write(make([]byte, ceilDiv(len(entry), 4)*4-len(entry)))
+	}
+}
+
+// readKeyValueData parses the key/value pairs of a KTX file. Values are returned without their terminating NUL
+func readKeyValueData(data []byte) map[string]string {
+	pairs := map[string]string{}
+	for len(data) >= 4 {
+		size := int(binary.LittleEndian.Uint32(data))
+		if size > len(data)-4 {
+			break
+		}
+
+		entry := data[4 : 4+size]
+		if i := bytes.IndexByte(entry, 0); i >= 0 {
+			pairs[string(entry[:i])] = strings.TrimRight(string(entry[i+1:]), "\x00")
+		}
+
+		if next := 4 + ceilDiv(size, 4)*4; next < len(data) {
+			data = data[next:]
+		} else {
+			break
+		}
+	}
+
+	return pairs
+}
+
+// EncodeKTX converts a legacy PVR texture consisting of header and data to a KTX 1 file and writes it to w.
+// Mip levels, surfaces and cube map faces are preserved
+func EncodeKTX(w io.Writer, header PVRTC2Header, data []byte) error {
+	format, ok := findTextureFormat(header)
+	if !ok {
+		return fmt.Errorf("%s textures can't be stored in KTX files", header.PixelTypeName())
+	}
+
+	levels, err := baseLevels(header, len(data))
+	if err != nil {
+		return err
+	}
+	levelMajorData, err := toLevelMajor(header, data)
+	if err != nil {
+		return err
+	}
+
+	elements, faces := ktxLayout(header)
+	ktxHeader := KTXHeader{
+		Endianness:           KTX_ENDIANNESS,
+		GLType:               format.glType,
+		GLTypeSize:           format.glTypeSize,
+		GLFormat:             format.glFormat,
+		GLInternalFormat:     format.glInternalFormat,
+		GLBaseInternalFormat: format.glBaseInternalFormat,
+		PixelWidth:           header.Width,
+		PixelHeight:          header.Height,
+		NumberOfFaces:        uint32(faces),
+		NumberOfMipmapLevels: uint32(len(levels)),
+	}
+	if elements > 1 {
+		ktxHeader.NumberOfArrayElements = uint32(elements)
+	}
+	if format.glInternalFormatRGB != 0 && header.PixelFormatFlags&PVR_FLAG_ALPHA == 0 {
+		ktxHeader.GLInternalFormat = format.glInternalFormatRGB
+	}
+
+	// rows are stored top to bottom unless the texture is flipped
+	orientation := "S=r,T=d"
+	if header.PixelFormatFlags&PVR_FLAG_VERTICAL_FLIP != 0 {
+		orientation = "S=r,T=u"
+	}
+
+	var keyValueData bytes.Buffer
+	writeKeyValueData(&keyValueData, map[string]string{KTX_ORIENTATION_KEY: orientation, KTX_WRITER_KEY: KTX_WRITER})
+	ktxHeader.BytesOfKeyValueData = uint32(keyValueData.Len())
+
+	var buf bytes.Buffer
+	buf.WriteString(KTX_IDENTIFIER)
+	if err := binary.Write(&buf, binary.LittleEndian, ktxHeader); err != nil {
+		return err
+	}
+	buf.Write(keyValueData.Bytes())
+
+	for _, level := range levels {
+		padding := ktxRowPadding(header, level.Width)
+		imageSize := level.Size + padding*level.Height
+		if elements == 1 && faces == 6 {
+			// the image size of non-array cube maps covers a single face
+			binary.Write(&buf, binary.LittleEndian, uint32(imageSize))
+		} else {
+			binary.Write(&buf, binary.LittleEndian, uint32(imageSize*elements*faces))
+		}
+
+		for i := 0; i < elements*faces; i++ {
+			image := levelMajorData[:level.Size]
+			levelMajorData = levelMajorData[level.Size:]
+			if padding == 0 {
+				buf.Write(image)
+			} else {
+				rowSize := level.Size / level.Height
+				for y := 0; y < level.Height; y++ {
+					buf.Write(image[y*rowSize : (y+1)*rowSize])
+					buf.Write(make([]byte, padding))
+				}
+			}
+
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// isKTX checks whether data starts with the identifier of a KTX 1 file
+func isKTX(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(KTX_IDENTIFIER))
+}
+
+func decodeKTX(r *mtxReader) (PVRTC2Header, []byte, error) {
+	if err := r.need("KTX header", int64(len(KTX_IDENTIFIER))+13*4); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+	r.Seek(int64(len(KTX_IDENTIFIER)), io.SeekCurrent)
+
+	ktxHeader := KTXHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &ktxHeader); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	if ktxHeader.Endianness != KTX_ENDIANNESS {
+		return PVRTC2Header{}, nil, r.errorAt(12, "KTX endianness", errors.New("big-endian files are unsupported"))
+	} else if ktxHeader.PixelDepth > 1 {
+		return PVRTC2Header{}, nil, r.errorAt(44, "KTX pixel depth", errors.New("volume textures are unsupported"))
+	} else if err := r.limits.CheckImage(int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight)); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(36, "KTX dimensions", err)
+	}
+
+	pixelType, alpha, found := uint32(0), false, false
+	for _, f := range textureFormats {
+		if f.isCompressed() && ktxHeader.GLType == 0 && (ktxHeader.GLInternalFormat == f.glInternalFormat || ktxHeader.GLInternalFormat == f.glInternalFormatRGB) ||
+			!f.isCompressed() && ktxHeader.GLType == f.glType && ktxHeader.GLFormat == f.glFormat {
+			pixelType, alpha, found = f.pixelType, f.glInternalFormatRGB != 0 && ktxHeader.GLInternalFormat == f.glInternalFormat, true
+			break
+		}
+	}
+	if !found {
+		err := fmt.Errorf("type 0x%04X, format 0x%04X and internal format 0x%04X are unsupported", ktxHeader.GLType, ktxHeader.GLFormat, ktxHeader.GLInternalFormat)
+		return PVRTC2Header{}, nil, r.errorAt(16, "KTX pixel format", err)
+	}
+
+	keyValueData, err := r.readField("KTX key/value data", int64(ktxHeader.BytesOfKeyValueData))
+	if err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	if err := checkTextureLayout(int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight), int(ktxHeader.NumberOfMipmapLevels),
+		int(ktxHeader.NumberOfArrayElements), int(ktxHeader.NumberOfFaces), r.Len(), r.limits); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(48, "KTX layout", err)
+	}
+
+	header, err := newLegacyPVRHeader(pixelType, int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight),
+		int(ktxHeader.NumberOfMipmapLevels), int(ktxHeader.NumberOfArrayElements), int(ktxHeader.NumberOfFaces))
+	if err != nil {
+		return header, nil, err
+	}
+	if alpha {
+		header.PixelFormatFlags |= PVR_FLAG_ALPHA
+	}
+	if strings.Contains(readKeyValueData(keyValueData)[KTX_ORIENTATION_KEY], "T=u") {
+		header.PixelFormatFlags |= PVR_FLAG_VERTICAL_FLIP
+	}
+
+	levels, err := baseLevels(header, r.Len())
+	if err != nil {
+		return header, nil, err
+	}
+
+	elements, faces := ktxLayout(header)
+	var levelMajorData []byte
+	for _, level := range levels {
+		if _, err := r.readField(fmt.Sprintf("KTX mip level %d size", level.Level), 4); err != nil {
+			return header, nil, err
+		}
+
+		padding := ktxRowPadding(header, level.Width)
+		imageSize := level.Size + padding*level.Height
+		for i := 0; i < elements*faces; i++ {
+			image, err := r.readField(fmt.Sprintf("KTX mip level %d", level.Level), int64(imageSize))
+			if err != nil {
+				return header, nil, err
+			}
+
+			if padding == 0 {
+				levelMajorData = append(levelMajorData, image...)
+			} else {
+				rowSize := level.Size / level.Height
+				for y := 0; y < level.Height; y++ {
+					levelMajorData = append(levelMajorData, image[y*(rowSize+padding):y*(rowSize+padding)+rowSize]...)
+				}
+			}
+		}
+	}
+
+	data, err := fromLevelMajor(header, levelMajorData)
+	return header, data, err
+}
+
+// ktx2DataFormatDescriptor describes the header's pixel format as a basic data format descriptor
+func ktx2DataFormatDescriptor(h PVRTC2Header) []byte {
+	type sample struct {
+		bitOffset, bitLength, channel int
+	}
+
+	model, blockWidth, blockHeight, bytesPerBlock := KHR_DF_MODEL_RGBSDA, 1, 1, 0
+	var samples []sample
+	switch h.PixelType() {
+	case PVR_OGL_PVRTC4, PVR_MGL_PVRTC4:
+		model, blockWidth, blockHeight, bytesPerBlock = KHR_DF_MODEL_PVRTC, 4, 4, 8
+	case PVR_OGL_PVRTC2, PVR_MGL_PVRTC2:
+		model, blockWidth, blockHeight, bytesPerBlock = KHR_DF_MODEL_PVRTC, 8, 4, 8
+	case PVR_OGL_PVRTCII4:
+		model, blockWidth, blockHeight, bytesPerBlock = KHR_DF_MODEL_PVRTC2, 4, 4, 8
+	case PVR_OGL_PVRTCII2:
+		model, blockWidth, blockHeight, bytesPerBlock = KHR_DF_MODEL_PVRTC2, 8, 4, 8
+	case PVR_ETC_RGB_4BPP:
+		model, blockWidth, blockHeight, bytesPerBlock = KHR_DF_MODEL_ETC1, 4, 4, 8
+	}
+
+	if model == KHR_DF_MODEL_RGBSDA {
+		masks, _ := h.bitMasks()
+		bytesPerBlock = masks.bitCount / 8
+		for _, channel := range []struct {
+			mask uint32
+			id   int
+		}{{masks.r, KHR_DF_CHANNEL_RGBSDA_R}, {masks.g, KHR_DF_CHANNEL_RGBSDA_G}, {masks.b, KHR_DF_CHANNEL_RGBSDA_B}, {masks.a, KHR_DF_CHANNEL_RGBSDA_A}} {
+			if channel.mask != 0 {
+				samples = append(samples, sample{bits.TrailingZeros32(channel.mask), bits.OnesCount32(channel.mask), channel.id})
+			}
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].bitOffset < samples[j].bitOffset })
+	} else if h.PixelFormatFlags&PVR_FLAG_ALPHA != 0 {
+		samples = []sample{{0, bytesPerBlock * 8, KHR_DF_CHANNEL_ALPHA}}
+	} else {
+		samples = []sample{{0, bytesPerBlock * 8, 0}}
+	}
+
+	blockSize := KHR_DF_BASIC_BLOCK_SIZE + KHR_DF_SAMPLE_SIZE*len(samples)
+	dfd := make([]byte, 4+blockSize)
+	binary.LittleEndian.PutUint32(dfd, uint32(len(dfd)))
+	binary.LittleEndian.PutUint32(dfd[8:], uint32(KHR_DF_VERSION|blockSize<<16))
+	dfd[12], dfd[13], dfd[14] = byte(model), KHR_DF_PRIMARIES_BT709, KHR_DF_TRANSFER_LINEAR
+	dfd[16], dfd[17] = byte(blockWidth-1), byte(blockHeight-1)
+	dfd[20] = byte(bytesPerBlock)
+
+	for i, s := range samples {
+		b := dfd[4+KHR_DF_BASIC_BLOCK_SIZE+i*KHR_DF_SAMPLE_SIZE:]
+		binary.LittleEndian.PutUint16(b, uint16(s.bitOffset))
+		b[2], b[3] = byte(s.bitLength-1), byte(s.channel)
+		upper := uint32(0xFFFFFFFF)
+		if s.bitLength < 32 {
+			upper = 1<<s.bitLength - 1
+		}
+		binary.LittleEndian.PutUint32(b[12:], upper)
+	}
+
+	return dfd
+}
+
+// ktx2HasAlpha checks whether any sample of a basic data format descriptor describes an alpha channel
+func ktx2HasAlpha(dfd []byte) bool {
+	if len(dfd) < 4+KHR_DF_BASIC_BLOCK_SIZE {
+		return false
+	}
+
+	blockSize := int(binary.LittleEndian.Uint16(dfd[10:]))
+	for offset := 4 + KHR_DF_BASIC_BLOCK_SIZE; offset+KHR_DF_SAMPLE_SIZE <= 4+blockSize && offset+KHR_DF_SAMPLE_SIZE <= len(dfd); offset += KHR_DF_SAMPLE_SIZE {
+		if dfd[offset+3]&KHR_DF_CHANNEL_MASK == KHR_DF_CHANNEL_ALPHA {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncodeKTX2 converts a legacy PVR texture consisting of header and data to a KTX 2 file and writes it to w.
+// Mip levels, surfaces and cube map faces are preserved
+func EncodeKTX2(w io.Writer, header PVRTC2Header, data []byte) error {
+	format, ok := findTextureFormat(header)
+	if !ok || format.vkFormat == 0 {
+		return fmt.Errorf("%s textures can't be stored in KTX 2 files", header.PixelTypeName())
+	}
+
+	levels, err := baseLevels(header, len(data))
+	if err != nil {
+		return err
+	}
+	levelMajorData, err := toLevelMajor(header, data)
+	if err != nil {
+		return err
+	}
+
+	elements, faces := ktxLayout(header)
+	ktxHeader := KTX2Header{
+		VKFormat:    format.vkFormat,
+		TypeSize:    format.glTypeSize,
+		PixelWidth:  header.Width,
+		PixelHeight: header.Height,
+		FaceCount:   uint32(faces),
+		LevelCount:  uint32(len(levels)),
+	}
+	if elements > 1 {
+		ktxHeader.LayerCount = uint32(elements)
+	}
+
+	dfd := ktx2DataFormatDescriptor(header)
+	keyValues := map[string]string{KTX_WRITER_KEY: KTX_WRITER}
+	if header.PixelFormatFlags&PVR_FLAG_VERTICAL_FLIP != 0 {
+		keyValues[KTX_ORIENTATION_KEY] = "ru"
+	}
+	var keyValueData bytes.Buffer
+	writeKeyValueData(&keyValueData, keyValues)
+
+	indexSize := len(levels) * binary.Size(ktx2LevelIndex{})
+	ktxHeader.DFDByteOffset = uint32(len(KTX2_IDENTIFIER) + binary.Size(ktxHeader) + indexSize)
+	ktxHeader.DFDByteLength = uint32(len(dfd))
+	ktxHeader.KVDByteOffset = ktxHeader.DFDByteOffset + ktxHeader.DFDByteLength
+	ktxHeader.KVDByteLength = uint32(keyValueData.Len())
+
+	// levels are stored from smallest to largest, each aligned to both the texel block size and 4 bytes
+	alignment := int(dfd[20])
+	if alignment == 0 {
+		return fmt.Errorf("the texel block size of %s textures is unknown", header.PixelTypeName())
+	}
+	for alignment%4 != 0 {
+		alignment += int(dfd[20])
+	}
+
+	index := make([]ktx2LevelIndex, len(levels))
+	offset := int(ktxHeader.KVDByteOffset + ktxHeader.KVDByteLength)
+	levelOffsets := make([]int, len(levels))
+	for i := range levels {
+		if i > 0 {
+			levelOffsets[i] = levelOffsets[i-1] + levels[i-1].Size*elements*faces
+		}
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		offset = ceilDiv(offset, alignment) * alignment
+		size := levels[i].Size * elements * faces
+		index[i] = ktx2LevelIndex{uint64(offset), uint64(size), uint64(size)}
+		offset += size
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(KTX2_IDENTIFIER)
+	if err := binary.Write(&buf, binary.LittleEndian, ktxHeader); err != nil {
+		return err
+	} else if err := binary.Write(&buf, binary.LittleEndian, index); err != nil {
+		return err
+	}
+	buf.Write(dfd)
+	buf.Write(keyValueData.Bytes())
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		buf.Write(make([]byte, int(index[i].ByteOffset)-buf.Len()))
+		buf.Write(levelMajorData[levelOffsets[i] : levelOffsets[i]+int(index[i].ByteLength)])
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// isKTX2 checks whether data starts with the identifier of a KTX 2 file
+func isKTX2(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(KTX2_IDENTIFIER))
+}
+
+func decodeKTX2(r *mtxReader) (PVRTC2Header, []byte, error) {
+	if err := r.need("KTX 2 header", int64(len(KTX2_IDENTIFIER)+binary.Size(KTX2Header{}))); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+	r.Seek(int64(len(KTX2_IDENTIFIER)), io.SeekCurrent)
+
+	ktxHeader := KTX2Header{}
+	if err := binary.Read(r, binary.LittleEndian, &ktxHeader); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	if ktxHeader.SupercompressionScheme != KTX2_SUPERCOMPRESSION_NONE {
+		return PVRTC2Header{}, nil, r.errorAt(44, "KTX 2 supercompression scheme", fmt.Errorf("%d is unsupported", ktxHeader.SupercompressionScheme))
+	} else if ktxHeader.PixelDepth > 1 {
+		return PVRTC2Header{}, nil, r.errorAt(28, "KTX 2 pixel depth", errors.New("volume textures are unsupported"))
+	} else if err := r.limits.CheckImage(int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight)); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(20, "KTX 2 dimensions", err)
+	}
+
+	pixelType, found := uint32(0), false
+	for _, f := range textureFormats {
+		if f.vkFormat != 0 && f.vkFormat == ktxHeader.VKFormat {
+			pixelType, found = f.pixelType, true
+			break
+		}
+	}
+	if !found {
+		return PVRTC2Header{}, nil, r.errorAt(12, "KTX 2 format", fmt.Errorf("%d is unsupported", ktxHeader.VKFormat))
+	}
+
+	if err := checkTextureLayout(int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight), int(ktxHeader.LevelCount),
+		int(ktxHeader.LayerCount), int(ktxHeader.FaceCount), r.Len(), r.limits); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(32, "KTX 2 layout", err)
+	}
+
+	header, err := newLegacyPVRHeader(pixelType, int(ktxHeader.PixelWidth), int(ktxHeader.PixelHeight),
+		int(ktxHeader.LevelCount), int(ktxHeader.LayerCount), int(ktxHeader.FaceCount))
+	if err != nil {
+		return header, nil, err
+	}
+
+	levels, err := baseLevels(header, r.Len())
+	if err != nil {
+		return header, nil, err
+	}
+
+	index := make([]ktx2LevelIndex, len(levels))
+	if err := r.need("KTX 2 level index", int64(binary.Size(index))); err != nil {
+		return header, nil, err
+	} else if err := binary.Read(r, binary.LittleEndian, index); err != nil {
+		return header, nil, err
+	}
+
+	file := make([]byte, r.Size())
+	r.ReadAt(file, 0)
+	if end := uint64(ktxHeader.KVDByteOffset) + uint64(ktxHeader.KVDByteLength); end <= uint64(len(file)) {
+		keyValues := readKeyValueData(file[ktxHeader.KVDByteOffset:end])
+		if strings.HasPrefix(keyValues[KTX_ORIENTATION_KEY], "ru") {
+			header.PixelFormatFlags |= PVR_FLAG_VERTICAL_FLIP
+		}
+	}
+	// KTX 2 formats don't tell textures with and without alpha apart, but the data format descriptor does
+	if end := uint64(ktxHeader.DFDByteOffset) + uint64(ktxHeader.DFDByteLength); end <= uint64(len(file)) && ktx2HasAlpha(file[ktxHeader.DFDByteOffset:end]) {
+		header.PixelFormatFlags |= PVR_FLAG_ALPHA
+	}
+
+	elements, faces := ktxLayout(header)
+	var levelMajorData []byte
+	for i, level := range levels {
+		size := uint64(level.Size * elements * faces)
+		if index[i].ByteLength < size || index[i].ByteOffset+size > uint64(len(file)) {
+			return header, nil, r.errorAt(int64(len(KTX2_IDENTIFIER)+binary.Size(ktxHeader)+i*binary.Size(ktx2LevelIndex{})),
+				fmt.Sprintf("KTX 2 mip level %d", i), ErrTruncated)
+		}
+
+		levelMajorData = append(levelMajorData, file[index[i].ByteOffset:index[i].ByteOffset+size]...)
+	}
+
+	data, err := fromLevelMajor(header, levelMajorData)
+	return header, data, err
+}
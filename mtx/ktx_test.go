@@ -0,0 +1,60 @@
+package mtx
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestKTX2KeepsAlphaFlag(t *testing.T) {
+	for _, flags := range []uint32{0, PVR_FLAG_ALPHA} {
+		header, err := newLegacyPVRHeader(PVR_OGL_PVRTC4, 8, 8, 1, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header.PixelFormatFlags |= flags
+
+		buf := bytes.Buffer{}
+		if err := EncodeKTX2(&buf, header, make([]byte, 32)); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, _, err := DecodePVR(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if decoded.PixelFormatFlags != header.PixelFormatFlags {
+			t.Errorf("got flags 0x%08X, want 0x%08X", decoded.PixelFormatFlags, header.PixelFormatFlags)
+		}
+	}
+}
+
+func TestEncodeContainersRejectInconsistentLayouts(t *testing.T) {
+	encoders := map[string]func(io.Writer, PVRTC2Header, []byte) error{
+		"KTX":   EncodeKTX,
+		"KTX 2": EncodeKTX2,
+		"DDS":   EncodeDDS,
+	}
+
+	// an 8x8 texture has 4 mip levels at most, and the header's data size only covers those
+	tooManyLevels, err := newLegacyPVRHeader(PVR_OGL_RGBA_8888, 8, 8, 4, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooManyLevels.MipMapCount = 6
+
+	// every surface takes up at least one byte, so 64 bytes can't hold 100 of them
+	tooManySurfaces, err := newLegacyPVRHeader(PVR_OGL_RGBA_8888, 4, 4, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooManySurfaces.NumSurfaces = 100
+
+	for name, encode := range encoders {
+		if err := encode(io.Discard, tooManyLevels, make([]byte, tooManyLevels.CompressedDataSize)); err == nil {
+			t.Errorf("%s with too many mip levels: got no error", name)
+		}
+		if err := encode(io.Discard, tooManySurfaces, make([]byte, tooManySurfaces.CompressedDataSize)); err == nil {
+			t.Errorf("%s with too many surfaces: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package mtx
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultMaxDimension = 4096
+	DefaultMaxPixels    = DefaultMaxDimension * DefaultMaxDimension
+	DefaultMaxMaskBytes = DefaultMaxPixels // masks store one byte per pixel
+	DefaultMaxFileSize  = 1 << 30          // 1 GiB
+	DefaultMaxTiers     = 8
+	DefaultMaxSurfaces  = 1024 // enough for arrays of 170 cube maps
+)
+
+// Limits bounds the resources used while reading and writing MTX and PVR files,
+// so untrusted files can't make the reader allocate huge amounts of memory.
+// The zero value of any field selects its default, negative values disable the limit
+type Limits struct {
+	MaxWidth     int
+	MaxHeight    int
+	MaxPixels    int   // width times height of a single image
+	MaxMaskBytes int   // decompressed size of a single MTXv1 mask
+	MaxFileSize  int64 // size of input and output files
+	MaxTiers     int   // number of tiers in MTXv0 and MTXv1 files
+	MaxSurfaces  int   // number of surfaces in textures, counting every cube map face
+}
+
+// LimitError reports a value exceeding one of the configured Limits
+type LimitError struct {
+	Name  string // name of the checked value, e.g. "width"
+	Value int64
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Name, e.Value, e.Limit)
+}
+
+// Is makes LimitErrors match ErrLimitExceeded
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// DefaultLimits returns the limits used when no limits are given.
+// image.Decode and image.DecodeConfig always use them, as the image package has no way to pass others
+func DefaultLimits() *Limits {
+	return &Limits{
+		MaxWidth:     DefaultMaxDimension,
+		MaxHeight:    DefaultMaxDimension,
+		MaxPixels:    DefaultMaxPixels,
+		MaxMaskBytes: DefaultMaxMaskBytes,
+		MaxFileSize:  DefaultMaxFileSize,
+		MaxTiers:     DefaultMaxTiers,
+		MaxSurfaces:  DefaultMaxSurfaces,
+	}
+}
+
+// withDefaults returns a copy of l with all unset fields replaced by their defaults. l may be nil
+func (l *Limits) withDefaults() *Limits {
+	defaults := DefaultLimits()
+	if l == nil {
+		return defaults
+	}
+
+	limits := *l
+	if limits.MaxWidth == 0 {
+		limits.MaxWidth = defaults.MaxWidth
+	}
+	if limits.MaxHeight == 0 {
+		limits.MaxHeight = defaults.MaxHeight
+	}
+	if limits.MaxPixels == 0 {
+		limits.MaxPixels = defaults.MaxPixels
+	}
+	if limits.MaxMaskBytes == 0 {
+		limits.MaxMaskBytes = defaults.MaxMaskBytes
+	}
+	if limits.MaxFileSize == 0 {
+		limits.MaxFileSize = defaults.MaxFileSize
+	}
+	if limits.MaxTiers == 0 {
+		limits.MaxTiers = defaults.MaxTiers
+	}
+	if limits.MaxSurfaces == 0 {
+		limits.MaxSurfaces = defaults.MaxSurfaces
+	}
+
+	return &limits
+}
+
+// check returns a *LimitError if value exceeds limit. Negative limits are disabled
+func check(name string, value int64, limit int64) error {
+	if limit >= 0 && value > limit {
+		return &LimitError{Name: name, Value: value, Limit: limit}
+	}
+
+	return nil
+}
+
+// CheckImage makes sure an image of the given dimensions doesn't exceed the limits. l may be nil
+func (l *Limits) CheckImage(width int, height int) error {
+	l = l.withDefaults()
+	if err := check("width", int64(width), int64(l.MaxWidth)); err != nil {
+		return err
+	}
+	if err := check("height", int64(height), int64(l.MaxHeight)); err != nil {
+		return err
+	}
+
+	return check("pixel count", int64(width)*int64(height), int64(l.MaxPixels))
+}
+
+// CheckFileSize makes sure a file of the given size doesn't exceed the limits. l may be nil
+func (l *Limits) CheckFileSize(size int64) error {
+	return check("file size", size, l.withDefaults().MaxFileSize)
+}
+
+// CheckTiers makes sure a file with the given number of tiers doesn't exceed the limits. l may be nil
+func (l *Limits) CheckTiers(count int) error {
+	return check("tier count", int64(count), int64(l.withDefaults().MaxTiers))
+}
+
+// CheckSurfaces makes sure a texture with the given number of surfaces doesn't exceed the limits. l may be nil
+func (l *Limits) CheckSurfaces(count int) error {
+	return check("surface count", int64(count), int64(l.withDefaults().MaxSurfaces))
+}
+
+// checkMaskSize makes sure a decompressed mask of the given size doesn't exceed the limits
+func (l *Limits) checkMaskSize(size int) error {
+	return check("decompressed mask size", int64(size), int64(l.withDefaults().MaxMaskBytes))
+}
+
+// checkFile makes sure none of the images stored in f exceed the limits
+func (l *Limits) checkFile(f *File) error {
+	if f.Version == 2 {
+		return l.CheckImage(int(f.PVRHeader.Width), int(f.PVRHeader.Height))
+	}
+
+	if err := l.CheckTiers(len(f.Tiers)); err != nil {
+		return err
+	}
+
+	for i, tier := range f.Tiers {
+		if err := l.CheckImage(tier.Width, tier.Height); err != nil {
+			return fmt.Errorf("tier %d: %w", i+1, err)
+		}
+		if f.Version == 1 {
+			if err := l.checkMaskSize(tier.Width * tier.Height); err != nil {
+				return fmt.Errorf("tier %d: %w", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// limitWriter passes writes on to w until more than limit bytes would have been written in total
+type limitWriter struct {
+	w       io.Writer
+	written int64
+	limit   int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if err := check("file size", lw.written+int64(len(p)), lw.limit); err != nil {
+		return 0, err
+	}
+
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+	return n, err
+}
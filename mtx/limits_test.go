@@ -0,0 +1,111 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+// assertLimitError fails the test unless err is a *LimitError for the value called name
+func assertLimitError(t *testing.T, what string, err error, name string) {
+	t.Helper()
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("%s: got %v, want a LimitError", what, err)
+	} else if limitErr.Name != name {
+		t.Errorf("%s: got a LimitError for the %s, want one for the %s", what, limitErr.Name, name)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	files := make(map[uint32][]byte)
+	for version := uint32(0); version <= 2; version++ {
+		data, err := encodeTestImage(version, testImage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[version] = data
+	}
+
+	// an 8x8 PVRTC texture with two surfaces
+	header, err := newLegacyPVRHeader(PVR_OGL_PVRTC4, 8, 8, 1, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	surfaces := bytes.Buffer{}
+	if err := Encode(&surfaces, NewPVRFile(header, make([]byte, header.CompressedDataSize)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files themselves stay within the default limits, and each test lowers one of them
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+	}{
+		{"file size", files[0], Limits{MaxFileSize: 100}},
+		{"width", files[0], Limits{MaxWidth: 32}},
+		{"height", files[1], Limits{MaxHeight: 32}},
+		{"pixel count", files[2], Limits{MaxPixels: 1000}},
+		{"tier count", files[1], Limits{MaxTiers: 1}},
+		{"decompressed mask size", files[1], Limits{MaxMaskBytes: 1000}},
+		{"surface count", surfaces.Bytes(), Limits{MaxSurfaces: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWithLimits(bytes.NewReader(test.data), &test.limits)
+			assertLimitError(t, "decoding", err, test.name)
+
+			mtxFile, err := Decode(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertLimitError(t, "encoding", Encode(io.Discard, mtxFile, &BakeOptions{Limits: &test.limits}), test.name)
+		})
+	}
+
+	// baking images goes through the same checks
+	_, err = encodeTestImage(1, testImage(), &BakeOptions{Limits: &Limits{MaxWidth: 32}})
+	assertLimitError(t, "baking", err, "width")
+}
+
+func TestDefaultLimits(t *testing.T) {
+	// Decode and image.Decode always use the default limits
+	tests := []struct {
+		name string
+		data func() []byte
+	}{
+		{"width", func() []byte {
+			data := mtxv2File(0, 1)
+			binary.LittleEndian.PutUint32(data[HEADER_V2_SIZE+8:], DefaultMaxDimension+1)
+			return data
+		}},
+		{"height", func() []byte {
+			data := mtxv2File(0, 1)
+			binary.LittleEndian.PutUint32(data[HEADER_V2_SIZE+4:], DefaultMaxDimension+1)
+			return data
+		}},
+		{"surface count", func() []byte {
+			return mtxv2File(0, DefaultMaxSurfaces+1)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(test.data()))
+			assertLimitError(t, "Decode", err, test.name)
+
+			_, _, err = image.Decode(bytes.NewReader(test.data()))
+			assertLimitError(t, "image.Decode", err, test.name)
+
+			// the default limits apply whenever no limits are given
+			_, err = DecodeWithLimits(bytes.NewReader(test.data()), &Limits{})
+			assertLimitError(t, "DecodeWithLimits", err, test.name)
+		})
+	}
+}
@@ -3,314 +3,286 @@ package mtx
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
-	"image/png"
 	"io"
-	"io/fs"
-	"os"
-	"path/filepath"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	pngEnc = png.Encoder{
-		CompressionLevel: png.BestSpeed,
-	}
-)
-
-func extractMTXv0(file *os.File, fileInfo fs.FileInfo, dryRun bool) error {
+func extractMTXv0(r *mtxReader) (*File, error) {
 	// read MTX header
-	fileHeader, err := readHeaderV0V1(file)
+	if err := r.need("file header", HEADER_V0V1_SIZE); err != nil {
+		return nil, err
+	}
+	fileHeader, err := readHeaderV0V1(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// set up paths and file names for later
-	fileDir, fileBase := filepath.Split(file.Name())
-	fileBaseNoExt := strings.Split(fileBase, ".")[0]
+	mtxFile := &File{Version: 0, Header: fileHeader}
 
-	// variables for use in the loop
-	var chunkData []byte
-	blockLengths := [2]int{
-		int(fileHeader.LengthFirst),
-		int(fileHeader.LengthSecond),
+	regionLengths := [2]int64{
+		int64(fileHeader.LengthFirst),
+		int64(fileHeader.LengthSecond),
 	}
 
-	for i, length := range blockLengths {
-		imageIndex := i + 1
-
+	for i, length := range regionLengths {
 		if length == 0 {
-			log.Infof("Skipping image %d (no data)\n", imageIndex)
+			log.Debugf("Skipping region %d (no data)", i+1)
 			continue
 		}
 
-		// create new file path
-		newOutFilePath := filepath.Join(fileDir, fmt.Sprintf("%s%d.jpg", fileBaseNoExt, imageIndex))
+		r.block = len(mtxFile.Tiers) + 1
+		regionOffset := r.offset()
+		region, err := r.readField("color data", length)
+		if err != nil {
+			return nil, err
+		}
 
-		log.Infof("Extracting image %d…\n", imageIndex)
-		if chunkData, err = readSomeBytes(file, length); err != nil {
-			return err
+		// the first region holds all tiers but the largest one, so it may consist of several JPEG images.
+		// Data that can't be split is decoded as a single image, which reports any errors
+		chunks := [][]byte{region}
+		if i == 0 {
+			if images, ok := splitJPEGs(region); ok {
+				chunks = images
+			}
 		}
 
-		// write image data to file
-		if dryRun {
-			log.Debugf("Dry Run: skipping creation of %s", filepath.Base(newOutFilePath))
-		} else {
-			outImageFile, err := os.Create(newOutFilePath)
+		colorOffset := regionOffset
+		for _, chunkData := range chunks {
+			imageIndex := len(mtxFile.Tiers) + 1
+			r.block = imageIndex
+			log.Debugf("Reading image %d…", imageIndex)
+
+			if err := r.limits.CheckTiers(imageIndex); err != nil {
+				return nil, r.errorAt(colorOffset, "color data", err)
+			}
+
+			// check the image's dimensions before decoding it
+			colorImageConfig, _, err := image.DecodeConfig(bytes.NewReader(chunkData))
 			if err != nil {
-				return err
+				return nil, r.errorAt(colorOffset, "color data", err)
+			} else if err := r.limits.CheckImage(colorImageConfig.Width, colorImageConfig.Height); err != nil {
+				return nil, r.errorAt(colorOffset, "color data", err)
 			}
 
-			_, err = outImageFile.Write(chunkData)
+			colorImage, colorImageFormat, err := image.Decode(bytes.NewReader(chunkData))
 			if err != nil {
-				outImageFile.Close()
-				return err
+				return nil, r.errorAt(colorOffset, "color data", err)
 			}
 
-			outImageFile.Close()
+			log.Debugf("color%d decoded as %s", imageIndex, colorImageFormat)
+
+			mtxFile.Tiers = append(mtxFile.Tiers, &Tier{
+				Width:    colorImage.Bounds().Dx(),
+				Height:   colorImage.Bounds().Dy(),
+				Color:    colorImage,
+				RawColor: chunkData,
+			})
+			colorOffset += int64(len(chunkData))
 		}
 	}
 
-	pos, _ := file.Seek(0, io.SeekCurrent)
-	if pos < fileInfo.Size() {
-		log.Warnf("There is additional data in the file after %d bytes!", pos)
-	}
+	mtxFile.readBlockLengths = rawBlockLengths(0, mtxFile.Tiers)
 
-	log.Info("Done.")
+	if r.Len() > 0 {
+		log.Warnf("There is additional data in the file after %d bytes!", r.offset())
+		mtxFile.Trailing, _ = io.ReadAll(r)
+	}
 
-	return nil
+	return mtxFile, nil
 }
 
-func extractMTXv1(file *os.File, fileInfo fs.FileInfo, dryRun bool) error {
-	// set up paths and file names for later
-	fileDir, fileBase := filepath.Split(file.Name())
-	fileBaseNoExt := strings.Split(fileBase, ".")[0]
-
-	// get file size for later
-	fileSize := fileInfo.Size()
-
+func extractMTXv1(r *mtxReader) (*File, error) {
 	// read MTX header
-	_, err := readHeaderV0V1(file)
+	if err := r.need("file header", HEADER_V0V1_SIZE); err != nil {
+		return nil, err
+	}
+	fileHeader, err := readHeaderV0V1(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// setting up variables that are gonna be reused throughout the loop
-	var filePos int64
-	var chunkLength int
-	var chunkData []byte
+	mtxFile := &File{Version: 1, Header: fileHeader}
+
+	// blocks carry their own lengths, so they're read until the end of the file. Any beyond the header's lengths are unexpected
+	headerEnd := int64(HEADER_V0V1_SIZE) + int64(fileHeader.LengthFirst) + int64(fileHeader.LengthSecond)
+	warned := false
 
 	imageIndex := 1
-	for filePos < fileSize {
-		if imageIndex == 3 {
-			log.Warn("There is additional data after the expected two image blocks.")
+	for r.Len() > 0 {
+		r.block = imageIndex
+		if err := r.limits.CheckTiers(imageIndex); err != nil {
+			return nil, r.errorAt(r.offset(), "block", err)
+		} else if r.offset() >= headerEnd && !warned {
+			log.Warn("There is additional data after the image blocks the header describes.")
 			log.Warn("Extraction will continue, but errors might occur.")
+			warned = true
 		}
 
-		// create new file path
-		newOutFilePath := filepath.Join(fileDir, fmt.Sprintf("%s%d.png", fileBaseNoExt, imageIndex))
-
-		log.Infof("Extracting image %d…\n", imageIndex)
+		log.Debugf("Reading image %d…", imageIndex)
 
 		// get image header
-		blockHeader, err := readBlockHeaderV1(file)
+		blockHeaderOffset := r.offset()
+		if err := r.need("header", BLOCK_HEADER_V1_SIZE); err != nil {
+			return nil, err
+		}
+		blockHeader, err := readBlockHeaderV1(r)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// read the color data
-		if b, err := readSomeBytes(file, 4); err != nil {
-			return err
-		} else {
-			chunkLength = int(binary.LittleEndian.Uint32(b))
-		}
-		if chunkData, err = readSomeBytes(file, chunkLength); err != nil {
-			return err
+		colorOffset := r.offset() + 4
+		colorData, err := r.readChunk("color")
+		if err != nil {
+			return nil, err
 		}
 
 		// create reader around the color data chunk
-		chunkReader := bytes.NewReader(chunkData)
+		chunkReader := bytes.NewReader(colorData)
 
 		// load image details without decoding the image
 		colorImageConfig, colorImageFormat, err := image.DecodeConfig(chunkReader)
 		if err != nil {
-			return err
+			return nil, r.errorAt(colorOffset, "color data", err)
 		}
 
-		log.Debugf("color%d decoded as %s\n", imageIndex, colorImageFormat)
+		log.Debugf("color%d decoded as %s", imageIndex, colorImageFormat)
 
-		// if the image is bigger than the arbitrarily set limit, stop
-		if colorImageConfig.Width > MAX_IMAGE_BOUNDS || colorImageConfig.Height > MAX_IMAGE_BOUNDS {
-			return errors.New("image is larger than 4096 pixels on either the vertical or horizontal axis")
+		// if the image is bigger than the configured limits, stop
+		if err := r.limits.CheckImage(colorImageConfig.Width, colorImageConfig.Height); err != nil {
+			return nil, r.errorAt(colorOffset, "color data", err)
 		} else if colorImageConfig.Width != int(blockHeader.Width) || colorImageConfig.Height != int(blockHeader.Height) {
-			return errors.New("image/header dimension mismatch detected")
+			return nil, r.errorAt(blockHeaderOffset, "header", ErrDimensionMismatch)
 		}
 
 		// reset reader to the beginning and actually decode the image
-		chunkReader.Seek(0, io.SeekStart)
-		colorImage, colorImageFormat, err := image.Decode(chunkReader)
+		_, _ = chunkReader.Seek(0, io.SeekStart)
+		colorImage, _, err := image.Decode(chunkReader)
+		if err != nil {
+			return nil, r.errorAt(colorOffset, "color data", err)
+		}
 
-		filePos, _ = file.Seek(0, io.SeekCurrent)
-		log.Debugf("Position (after color%d): %d\n", imageIndex, filePos)
+		log.Debugf("Position (after color%d): %d", imageIndex, r.offset())
 
 		// get mask data
-		if b, err := readSomeBytes(file, 4); err != nil {
-			return err
-		} else {
-			chunkLength = int(binary.LittleEndian.Uint32(b))
-		}
-		if chunkData, err = readSomeBytes(file, chunkLength); err != nil {
-			return err
+		maskOffset := r.offset() + 4
+		maskData, err := r.readChunk("mask")
+		if err != nil {
+			return nil, err
 		}
 
-		filePos, _ = file.Seek(0, io.SeekCurrent)
-		log.Debugf("Position (after alpha%d): %d\n", imageIndex, filePos)
+		log.Debugf("Position (after alpha%d): %d", imageIndex, r.offset())
 
 		// decompress mask data and construct an image
-		chunkDataDecompressed, err := decompressZlibData(chunkData)
+		maskDataDecompressed, err := decompressZlibData(maskData, r.limits.MaxMaskBytes)
 		if err != nil {
-			return err
-		}
-
-		maskImage := newGrayFromRawData(chunkDataDecompressed, int(blockHeader.Width), int(blockHeader.Height))
-		if colorImageConfig.Width != int(blockHeader.Width) || colorImageConfig.Height != int(blockHeader.Height) {
-			return errors.New("size mismatch between color image and alpha mask")
+			return nil, r.errorAt(maskOffset, "mask data", err)
 		}
 
-		// convert color image to NRGBA and fill in the mask image's alpha values
-		rgba := imageToNRGBA(colorImage)
-		for idx, alpha := range maskImage.Pix {
-			alphaIdx := idx*4 + 3
-			rgba.Pix[alphaIdx] = alpha
+		if len(maskDataDecompressed) != int(blockHeader.Width)*int(blockHeader.Height) {
+			return nil, r.errorAt(maskOffset, "mask data", ErrMaskSizeMismatch)
 		}
+		maskImage := newGrayFromRawData(maskDataDecompressed, int(blockHeader.Width), int(blockHeader.Height))
 
-		if dryRun {
-			log.Debugf("Dry Run: skipping creation of %s", filepath.Base(newOutFilePath))
-		} else {
-			outImageFile, err := os.Create(newOutFilePath)
-			if err != nil {
-				return err
-			}
-
-			err = pngEnc.Encode(outImageFile, rgba)
-			if err != nil {
-				outImageFile.Close()
-				return err
-			}
-
-			outImageFile.Close()
-		}
+		mtxFile.Tiers = append(mtxFile.Tiers, &Tier{
+			Width:    int(blockHeader.Width),
+			Height:   int(blockHeader.Height),
+			Color:    colorImage,
+			Mask:     maskImage,
+			RawColor: colorData,
+			RawMask:  maskData,
+		})
 
 		imageIndex++
 	}
 
-	log.Info("Done.")
-
-	return nil
+	mtxFile.readBlockLengths = rawBlockLengths(1, mtxFile.Tiers)
+	return mtxFile, nil
 }
 
-func extractMTXv2(file *os.File, dryRun bool) error {
-	// read MTX header (immediately discarding it so Go doesn't complain)
-	_, err := readHeaderV2(file)
+func extractMTXv2(r *mtxReader) (*File, error) {
+	// read MTX header
+	if err := r.need("file header", HEADER_V2_SIZE); err != nil {
+		return nil, err
+	}
+	fileHeader, err := readHeaderV2(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pvrtcHeader, err := readPVRTC2Header(file)
+	pvrtcHeader, pvrtcData, err := decodePVR(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// make sure the PVR file uses a known format
-	if string(pvrtcHeader.Magic[:]) != "PVR!" {
-		return errors.New("unsupported type of PVR file")
+	mtxFile := NewPVRFile(pvrtcHeader, pvrtcData)
+	mtxFile.HeaderV2 = fileHeader
+	if fileHeader.Unknown != DEFAULT_V2_UNKNOWN {
+		log.Warnf("The MTXv2 header's unknown field is %d instead of the usual %d. It's kept as it is when repacking the file", fileHeader.Unknown, DEFAULT_V2_UNKNOWN)
 	}
 
-	// back up after reading the last header
-	_, _ = file.Seek(-PVRTC2_HEADER_SIZE, io.SeekCurrent)
-
-	// set up paths and file names for later
-	fileDir, fileBase := filepath.Split(file.Name())
-	fileBaseNoExt := strings.Split(fileBase, ".")[0]
-	newOutFilePath := filepath.Join(fileDir, fmt.Sprintf("%s.pvr", fileBaseNoExt))
-
-	log.Info("Extracting image…")
-	chunkData, err := readSomeBytes(file, int(pvrtcHeader.HeaderSize+pvrtcHeader.CompressedDataSize))
-	if err != nil {
-		return err
+	if r.Len() > 0 {
+		log.Warnf("There is additional data in the file after %d bytes!", r.offset())
+		mtxFile.Trailing, _ = io.ReadAll(r)
 	}
 
-	// write image data to file
-	if dryRun {
-		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(newOutFilePath))
-	} else {
-		outImageFile, err := os.Create(newOutFilePath)
-		if err != nil {
-			return err
-		}
+	return mtxFile, nil
+}
 
-		_, err = outImageFile.Write(chunkData)
-		if err != nil {
-			outImageFile.Close()
-			return err
-		}
+// readInput reads all of r, making sure it doesn't exceed the maximum file size
+func readInput(r io.Reader, limits *Limits) ([]byte, error) {
+	limits = limits.withDefaults()
+	if limits.MaxFileSize < 0 {
+		return io.ReadAll(r)
+	}
 
-		outImageFile.Close()
+	// read one byte more than allowed so oversized input can be detected
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	} else if err := limits.CheckFileSize(int64(len(data))); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return data, nil
+}
+
+// Decode reads an MTX file from r using the default limits. Problems with the file's structure are reported as *ParseError
+func Decode(r io.Reader) (*File, error) {
+	return DecodeWithLimits(r, nil)
 }
 
-func ExtractMTXFile(file string, dryRun bool) error {
-	// open file
-	f, err := os.Open(file)
+// DecodeWithLimits reads an MTX file from r like Decode, but rejects files exceeding limits, which may be nil
+func DecodeWithLimits(r io.Reader, limits *Limits) (*File, error) {
+	data, err := readInput(r, limits)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
 
-	// get file info and perform preliminary size check
-	fi, err := f.Stat()
-	if err != nil {
-		return errors.New("couldn't get file info")
-	} else if !fi.Mode().IsRegular() {
-		return errors.New("is a directory")
-	} else if fi.Size() < 64 { // 64 bytes = MTXv2 and PVRTC2 headers
-		return errors.New("file is too small to be an MTX file")
-	} else if fi.Size() > MAX_INPUT_FILE_SIZE {
-		return errors.New("file is larger than 1 GiB")
+	// perform preliminary size check
+	if len(data) < 64 { // 64 bytes = MTXv2 and PVRTC2 headers
+		return nil, &ParseError{Offset: 0, Field: "file header", Err: ErrTruncated}
 	}
 
 	// parse file header and run the appropriate converter
-	fileVersionBytes, _ := readSomeBytes(f, 4)
-	fileVersion := binary.LittleEndian.Uint32(fileVersionBytes)
-
-	_, _ = f.Seek(0, io.SeekStart)
+	fileVersion := binary.LittleEndian.Uint32(data)
+	dataReader := newMTXReader(data, limits)
 
 	switch fileVersion {
 	case 0:
 		log.Debug("Format: MTXv0")
-		if err := extractMTXv0(f, fi, dryRun); err != nil {
-			return err
-		}
+		return extractMTXv0(dataReader)
 	case 1:
 		log.Debug("Format: MTXv1")
-		if err := extractMTXv1(f, fi, dryRun); err != nil {
-			return err
-		}
+		return extractMTXv1(dataReader)
 	case 2:
 		log.Debug("Format: MTXv2")
-		if err := extractMTXv2(f, dryRun); err != nil {
-			return err
-		}
+		return extractMTXv2(dataReader)
 	default:
-		return errors.New(fmt.Sprintf("Unsupported MTX version 0x%X", fileVersion))
+		return nil, dataReader.errorAt(0, fmt.Sprintf("MTX version 0x%X", fileVersion), ErrUnsupportedVersion)
 	}
-
-	return nil
 }
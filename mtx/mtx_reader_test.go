@@ -0,0 +1,16 @@
+package mtx
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeRejectsSmallFiles(t *testing.T) {
+	_, err := Decode(bytes.NewReader(make([]byte, 10)))
+
+	var parseError *ParseError
+	if !errors.As(err, &parseError) || !errors.Is(err, ErrTruncated) {
+		t.Errorf("got %v, want a ParseError wrapping ErrTruncated", err)
+	}
+}
@@ -5,260 +5,238 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/disintegration/imaging"
-	log "github.com/sirupsen/logrus"
 	"image/jpeg"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
-)
 
-var (
-	jpegOpts *jpeg.Options = nil
+	log "github.com/sirupsen/logrus"
 )
 
-func createMTXv0(inFile *os.File, outFilePath string, dryRun bool) error {
-	img, err := imaging.Open(inFile.Name())
-	if err != nil {
-		return err
+// encodeColor returns the tier's JPEG data, encoding its color image with the tier's JPEGQuality, if set, if there is no raw data.
+// If opaque is set, the colors of invisible pixels are filled according to opts.Bleed and the alpha channel is ignored
+func (t *Tier) encodeColor(opts *BakeOptions, opaque bool) ([]byte, error) {
+	if t.RawColor != nil {
+		return t.RawColor, nil
 	}
 
-	imgBuf := new(bytes.Buffer)
-	err = jpeg.Encode(imgBuf, img, jpegOpts)
-	if err != nil {
-		return err
+	img := t.Color
+	if opaque {
+		// work on a copy so the tier's image isn't modified
+		nrgba := imageToNRGBA(t.Color)
+		bleedColors(nrgba, t.Mask, opts.Bleed)
+		makeAlphaChannelOpaque(nrgba)
+		img = nrgba
 	}
 
-	scaledImg := imaging.Resize(img, img.Bounds().Dx()/2, img.Bounds().Dy()/2, imaging.CatmullRom)
-	scaledImgBuf := new(bytes.Buffer)
-	err = jpeg.Encode(scaledImgBuf, scaledImg, jpegOpts)
-	if err != nil {
-		return err
+	jpegOptions := opts.jpegOptions()
+	if t.JPEGQuality != 0 {
+		jpegOptions.Quality = t.JPEGQuality
 	}
 
-	fileHeader := HeaderV0V1{
-		Magic:        0,
-		LengthFirst:  uint32(len(scaledImgBuf.Bytes())),
-		LengthSecond: uint32(len(imgBuf.Bytes())),
+	imgBuf := new(bytes.Buffer)
+	if err := jpeg.Encode(imgBuf, img, jpegOptions); err != nil {
+		return nil, err
 	}
 
-	if dryRun {
-		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(outFilePath))
+	return imgBuf.Bytes(), nil
+}
+
+// encodeMask returns the tier's zlib-compressed mask, compressing its mask image if there is no raw data.
+// Tiers without a mask get a fully opaque one
+func (t *Tier) encodeMask(opts *BakeOptions) ([]byte, error) {
+	if t.RawMask != nil {
+		return t.RawMask, nil
+	}
+
+	alpha := make([]byte, t.Width*t.Height)
+	if t.Mask == nil {
+		for i := range alpha {
+			alpha[i] = 0xFF
+		}
 	} else {
-		f, err := os.Create(outFilePath)
-		if err != nil {
-			return err
+		for y := 0; y < t.Height; y++ {
+			copy(alpha[y*t.Width:(y+1)*t.Width], t.Mask.Pix[y*t.Mask.Stride:])
 		}
-		defer f.Close()
-
-		binary.Write(f, binary.LittleEndian, fileHeader)
-		f.Write(scaledImgBuf.Bytes())
-		f.Write(imgBuf.Bytes())
 	}
 
-	return nil
+	return compressZlibData(alpha, *opts.ZlibLevel)
 }
 
-func createMTXv1(inFile *os.File, outFilePath string, dryRun bool) error {
-	rawImage, err := imaging.Open(inFile.Name())
-	if err != nil {
-		return err
+// headerV0V1 returns the header of an MTXv0 or MTXv1 file whose blocks have the given lengths.
+// If the blocks still have the lengths the file was read with, the lengths in its header are kept,
+// even if they don't cover the blocks as expected, so unchanged files are written byte for byte
+func (f *File) headerV0V1(blockLengths []int64) HeaderV0V1 {
+	if len(f.readBlockLengths) == len(blockLengths) {
+		unchanged := true
+		for i, length := range blockLengths {
+			unchanged = unchanged && length == f.readBlockLengths[i]
+		}
+		if unchanged {
+			return HeaderV0V1{Magic: f.Version, LengthFirst: f.Header.LengthFirst, LengthSecond: f.Header.LengthSecond}
+		}
 	}
 
-	// convert input image to NRGBA space and create a scaled-down copy
-	img := imageToNRGBA(rawImage)
-	scaledImg := imaging.Resize(img, img.Bounds().Dx()/2, img.Bounds().Dy()/2, imaging.CatmullRom)
-
-	// compress the original image's alpha channel into a memory buffer using zlib
-	originalAlphaCompressed, err := compressZlibData(getAlphaChannel(img))
-	if err != nil {
-		return err
+	regionLengths := tierRegionLengths(blockLengths)
+	return HeaderV0V1{
+		Magic:        f.Version,
+		LengthFirst:  uint32(regionLengths[0]),
+		LengthSecond: uint32(regionLengths[1]),
 	}
+}
 
-	// compress the scaled image's alpha channel into a memory buffer using zlib
-	scaledAlphaCompressed, err := compressZlibData(getAlphaChannel(scaledImg))
-	if err != nil {
-		return err
+func createMTXv0(w io.Writer, mtxFile *File, opts *BakeOptions) error {
+	if len(mtxFile.Tiers) == 0 {
+		return errors.New("MTXv0 files need to contain at least one image")
 	}
 
-	// make both images' alpha channels fully opaque so the JPEG encoding step doesn't mess with transparent pixels
-	makeAlphaChannelOpaque(img)
-	makeAlphaChannelOpaque(scaledImg)
-
-	// JPEG-encode original image into memory buffer
-	originalBuf := new(bytes.Buffer)
-	err = jpeg.Encode(originalBuf, img, jpegOpts)
-	if err != nil {
-		return err
+	// JPEG-encode every tier into its own memory buffer
+	imgBufs := make([][]byte, len(mtxFile.Tiers))
+	blockLengths := make([]int64, len(mtxFile.Tiers))
+	for i, tier := range mtxFile.Tiers {
+		imgBuf, err := tier.encodeColor(opts, false)
+		if err != nil {
+			return err
+		}
+		imgBufs[i] = imgBuf
+		blockLengths[i] = int64(len(imgBuf))
 	}
 
-	// JPEG-encode scaled image into memory buffer
-	scaledBuf := new(bytes.Buffer)
-	err = jpeg.Encode(scaledBuf, scaledImg, jpegOpts)
-	if err != nil {
+	// files with only one image store it in the second slot
+	fileHeader := mtxFile.headerV0V1(blockLengths)
+
+	if err := binary.Write(w, binary.LittleEndian, fileHeader); err != nil {
 		return err
 	}
-
-	// store buffer lengths for later
-	// make sure these are uint32s because binary.Write will simply write zero bytes when these are ints
-	originalBufLen := uint32(len(originalBuf.Bytes()))
-	originalAlphaLen := uint32(len(originalAlphaCompressed))
-	scaledBufLen := uint32(len(scaledBuf.Bytes()))
-	scaledAlphaLen := uint32(len(scaledAlphaCompressed))
-
-	fileHeader := HeaderV0V1{
-		Magic: 1,
-		// Length fields include block headers and chunk lengths
-		LengthFirst:  BLOCK_HEADER_V1_SIZE + 8 + scaledBufLen + scaledAlphaLen,
-		LengthSecond: BLOCK_HEADER_V1_SIZE + 8 + originalBufLen + originalAlphaLen,
+	for _, imgBuf := range imgBufs {
+		if _, err := w.Write(imgBuf); err != nil {
+			return err
+		}
 	}
 
-	blockHeader1 := BlockHeaderV1{
-		Magic:  1,
-		Width:  uint32(scaledImg.Bounds().Dx()),
-		Height: uint32(scaledImg.Bounds().Dy()),
-	}
+	return nil
+}
 
-	blockHeader2 := BlockHeaderV1{
-		Magic:  1,
-		Width:  uint32(img.Bounds().Dx()),
-		Height: uint32(img.Bounds().Dy()),
+func createMTXv1(w io.Writer, mtxFile *File, opts *BakeOptions) error {
+	if len(mtxFile.Tiers) == 0 {
+		return errors.New("MTXv1 files need to contain at least one image")
 	}
 
-	if dryRun {
-		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(outFilePath))
-	} else {
-		f, err := os.Create(outFilePath)
+	blocks := make([]*bytes.Buffer, len(mtxFile.Tiers))
+	blockLengths := make([]int64, len(mtxFile.Tiers))
+	for i, tier := range mtxFile.Tiers {
+		// compress the tier's alpha mask using zlib
+		alphaCompressed, err := tier.encodeMask(opts)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-
-		binary.Write(f, binary.LittleEndian, fileHeader)
 
-		binary.Write(f, binary.LittleEndian, blockHeader1)
-		binary.Write(f, binary.LittleEndian, scaledBufLen)
-		f.Write(scaledBuf.Bytes())
-		binary.Write(f, binary.LittleEndian, scaledAlphaLen)
-		f.Write(scaledAlphaCompressed)
+		// JPEG-encode the tier with a fully opaque alpha channel so the encoding step doesn't mess with transparent pixels.
+		// Invisible pixels get the colors of visible ones around them so they don't show up as halos when filtered
+		imgBuf, err := tier.encodeColor(opts, true)
+		if err != nil {
+			return err
+		}
 
-		binary.Write(f, binary.LittleEndian, blockHeader2)
-		binary.Write(f, binary.LittleEndian, originalBufLen)
-		f.Write(originalBuf.Bytes())
-		binary.Write(f, binary.LittleEndian, originalAlphaLen)
-		f.Write(originalAlphaCompressed)
-	}
+		blockHeader := BlockHeaderV1{
+			Magic:  1,
+			Width:  uint32(tier.Width),
+			Height: uint32(tier.Height),
+		}
 
-	return nil
-}
+		// make sure these are uint32s because binary.Write will simply write zero bytes when these are ints
+		block := new(bytes.Buffer)
+		_ = binary.Write(block, binary.LittleEndian, blockHeader)
+		_ = binary.Write(block, binary.LittleEndian, uint32(len(imgBuf)))
+		block.Write(imgBuf)
+		_ = binary.Write(block, binary.LittleEndian, uint32(len(alphaCompressed)))
+		block.Write(alphaCompressed)
 
-func createMTXv2(inFile *os.File, outFilePath string, dryRun bool) error {
-	fileHeader := HeaderV2{
-		Magic:   2,
-		Unknown: 256,
+		blocks[i] = block
+		blockLengths[i] = int64(block.Len())
 	}
 
-	f, err := os.Create(outFilePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	// Length fields include block headers and chunk lengths
+	fileHeader := mtxFile.headerV0V1(blockLengths)
 
-	inFileContents, err := io.ReadAll(inFile)
-	if err != nil {
+	if err := binary.Write(w, binary.LittleEndian, fileHeader); err != nil {
 		return err
 	}
-
-	if dryRun {
-		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(outFilePath))
-	} else {
-		binary.Write(f, binary.LittleEndian, fileHeader)
-		f.Write(inFileContents)
+	for _, block := range blocks {
+		if _, err := w.Write(block.Bytes()); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func CreateMTXFile(file string, mtxTargetVersion int, jpegQuality int, dryRun bool) error {
-	if mtxTargetVersion < -1 || mtxTargetVersion > 2 {
-		return errors.New(fmt.Sprintf("an MTX target version of %d is unsupported. Supported values are: -1, 0, 1, and 2", mtxTargetVersion))
+func createMTXv2(w io.Writer, mtxFile *File, limits *Limits) error {
+	if len(mtxFile.PVRData) == 0 {
+		return errors.New("MTXv2 files need to contain PVR data")
+	} else if err := checkPVR(mtxFile.PVRHeader, mtxFile.PVRData, limits); err != nil {
+		return err
 	}
 
-	fileDir, fileBase := filepath.Split(file)
-	fileNameSplit := strings.Split(fileBase, ".")
-	_, fileExt := fileNameSplit[0], strings.ToLower(fileNameSplit[len(fileNameSplit)-1])
-	newOutFilePath := filepath.Join(fileDir, fmt.Sprintf("%s.mtx", fileBase))
-
-	// Do preflight checks here so they won't have to be repeated in the other functions
-	if fileExt == "mtx" {
-		return errors.New("already an MTX file")
-	} else if fileExt == "jpeg" || fileExt == "jpg" {
-		if mtxTargetVersion == -1 {
-			mtxTargetVersion = 0
-		}
-		if mtxTargetVersion == 2 {
-			return errors.New("JPEG files are only supported with MTX target version 0 or 1")
-		}
-	} else if fileExt == "png" {
-		if mtxTargetVersion == -1 {
-			mtxTargetVersion = 1
-		}
-
-		if mtxTargetVersion == 2 {
-			return errors.New("PNG files are only supported with MTX target version 0 or 1")
-		}
-	} else if fileExt == "pvr" {
-		if mtxTargetVersion == -1 {
-			mtxTargetVersion = 2
-		}
-		if mtxTargetVersion != 2 {
-			return errors.New("PVR files are only supported with MTX target version 2")
-		}
-	} else {
-		return errors.New("unsupported file format")
+	fileHeader := HeaderV2{
+		Magic:   2,
+		Unknown: mtxFile.HeaderV2.Unknown,
 	}
 
-	log.Debugf("Selected MTX format: %d", mtxTargetVersion)
+	if err := binary.Write(w, binary.LittleEndian, fileHeader); err != nil {
+		return err
+	}
 
-	jpegOpts = &jpeg.Options{Quality: jpegQuality}
+	return EncodePVR(w, mtxFile.PVRHeader, mtxFile.PVRData)
+}
 
-	f, err := os.Open(file)
-	if err != nil {
+// Encode writes mtxFile to w in the MTX format given by its version.
+// Tiers with raw data are written as-is, all others are encoded using opts, which may be nil
+func Encode(w io.Writer, mtxFile *File, opts *BakeOptions) error {
+	opts = opts.withDefaults()
+	if err := opts.Limits.checkFile(mtxFile); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	// get file info and perform preliminary size check
-	fi, err := f.Stat()
-	if err != nil {
-		return errors.New("couldn't get file info")
-	} else if !fi.Mode().IsRegular() {
-		return errors.New("is a directory")
-	} else if fi.Size() > MAX_INPUT_FILE_SIZE {
-		return errors.New("file is larger than 1 GiB")
-	}
+	// fail as soon as the encoded file grows too large
+	w = &limitWriter{w: w, limit: opts.Limits.MaxFileSize}
 
-	// by this point, only valid input files for any given MTX target versions should remain
-	switch mtxTargetVersion {
+	var err error
+	switch mtxFile.Version {
 	case 0:
-		log.Debug("Format: MTXv0")
-		if err := createMTXv0(f, newOutFilePath, dryRun); err != nil {
-			return err
-		}
+		err = createMTXv0(w, mtxFile, opts)
 	case 1:
-		log.Debug("Format: MTXv1")
-		if err := createMTXv1(f, newOutFilePath, dryRun); err != nil {
-			return err
-		}
+		err = createMTXv1(w, mtxFile, opts)
 	case 2:
-		log.Debug("Format: MTXv2")
-		if err := createMTXv2(f, newOutFilePath, dryRun); err != nil {
-			return err
-		}
+		err = createMTXv2(w, mtxFile, opts.Limits)
 	default:
-		return errors.New("this isn't supposed to happen. please report this")
+		return fmt.Errorf("an MTX version of %d is unsupported. Supported values are: 0, 1, and 2", mtxFile.Version)
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	_, err = w.Write(mtxFile.Trailing)
+	return err
+}
+
+// WriteFile encodes mtxFile and writes it to opts.OutputPath, unless opts.DryRun is set.
+// The encoded data is returned either way
+func WriteFile(mtxFile *File, opts *BakeOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	if opts.OutputPath == "" {
+		return nil, errors.New("no output path given")
+	}
+
+	mtxBuf := new(bytes.Buffer)
+	if err := Encode(mtxBuf, mtxFile, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		log.Debugf("Dry Run: skipping creation of %s", filepath.Base(opts.OutputPath))
+		return mtxBuf.Bytes(), nil
+	}
+
+	return mtxBuf.Bytes(), os.WriteFile(opts.OutputPath, mtxBuf.Bytes(), 0644)
 }
@@ -0,0 +1,95 @@
+package mtx
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testImage returns a 64x64 gradient whose right half fades out, so both colors and transparency vary
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			a := uint8(0xFF)
+			if x >= 32 {
+				a = uint8((63 - x) * 8)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8((x + y) * 2), a})
+		}
+	}
+
+	return img
+}
+
+// encodeTestImage creates an MTX file of the given version from img and encodes it with opts
+func encodeTestImage(version uint32, img image.Image, opts *BakeOptions) ([]byte, error) {
+	mtxFile, err := NewFile(version, img, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = Encode(&buf, mtxFile, opts)
+	return buf.Bytes(), err
+}
+
+func TestEncodeConcurrently(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint32
+		opts    *BakeOptions
+	}{
+		{"v0 defaults", 0, nil},
+		{"v0 low quality", 0, &BakeOptions{JPEGQuality: 20, SmallJPEGQuality: 10, Filter: "box"}},
+		{"v1 defaults", 1, &BakeOptions{}},
+		{"v1 linear light", 1, &BakeOptions{LinearLight: true, Sharpen: 1, Bleed: BleedBlur}},
+		{"v1 three tiers", 1, &BakeOptions{Tiers: 3, Bleed: BleedSolid, OddSize: OddSizeRoundUp}},
+		{"v2 pvrtc4", 2, &BakeOptions{PVRFormat: PVRFormatPVRTC4}},
+		{"v2 etc1", 2, &BakeOptions{PVRFormat: PVRFormatETC1}},
+		{"v2 twiddled rgba4444", 2, &BakeOptions{PVRFormat: PVRFormatRGBA4444, TwiddlePVR: true}},
+	}
+
+	img := testImage()
+	want := make([][]byte, len(tests))
+	for i, test := range tests {
+		var err error
+		if want[i], err = encodeTestImage(test.version, img, test.opts); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+	}
+
+	// every option set is used by several goroutines at once, alongside all the others
+	const runs = 4
+	var wg sync.WaitGroup
+	for run := 0; run < runs; run++ {
+		for i, test := range tests {
+			wg.Add(1)
+			go func(i int, version uint32, opts *BakeOptions, name string) {
+				defer wg.Done()
+
+				got, err := encodeTestImage(version, img, opts)
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				} else if !bytes.Equal(got, want[i]) {
+					t.Errorf("%s: encoding concurrently changed the output", name)
+				}
+			}(i, test.version, test.opts, test.name)
+		}
+	}
+	wg.Wait()
+}
+
+func TestEncodeDoesNotModifyOptions(t *testing.T) {
+	opts := &BakeOptions{JPEGQuality: 50}
+	if _, err := encodeTestImage(1, testImage(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*opts, BakeOptions{JPEGQuality: 50}) {
+		t.Errorf("got %+v, want only JPEGQuality to be set", *opts)
+	}
+}
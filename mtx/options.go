@@ -0,0 +1,183 @@
+package mtx
+
+import (
+	"compress/zlib"
+	"image/jpeg"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	DefaultJPEGQuality = 90 // estimated from extracted JPEG files
+	DefaultTierCount   = 2
+	DefaultPVRFormat   = PVRFormatPVRTCII4 // like the games' own MTXv2 files
+	DefaultBleed       = BleedDilate
+	DefaultOddSize     = OddSizeRoundDown
+	DefaultFilter      = "catmullrom"
+)
+
+// resampleFilters maps names to the filters smaller tiers can be generated with
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"hermite":    imaging.Hermite,
+	"mitchell":   imaging.MitchellNetravali,
+	"catmullrom": imaging.CatmullRom,
+	"bspline":    imaging.BSpline,
+	"gaussian":   imaging.Gaussian,
+	"lanczos":    imaging.Lanczos,
+}
+
+// ResampleFilter returns the filter with the given name. ok is false for unknown names
+func ResampleFilter(name string) (filter imaging.ResampleFilter, ok bool) {
+	filter, ok = resampleFilters[name]
+	return filter, ok
+}
+
+// ResampleFilterNames returns the names of all supported filters in alphabetical order
+func ResampleFilterNames() []string {
+	names := make([]string, 0, len(resampleFilters))
+	for name := range resampleFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// PVRFormat names a pixel format MTXv2 textures can be created in
+type PVRFormat string
+
+const (
+	PVRFormatPVRTCII4 PVRFormat = "pvrtcii4"
+	PVRFormatPVRTCII2 PVRFormat = "pvrtcii2"
+	PVRFormatPVRTC4   PVRFormat = "pvrtc4"
+	PVRFormatPVRTC2   PVRFormat = "pvrtc2"
+	PVRFormatETC1     PVRFormat = "etc1"
+	PVRFormatRGBA8888 PVRFormat = "rgba8888"
+	PVRFormatRGBA4444 PVRFormat = "rgba4444"
+	PVRFormatRGB565   PVRFormat = "rgb565"
+	PVRFormatRGBA5551 PVRFormat = "rgba5551"
+	PVRFormatARGB1555 PVRFormat = "argb1555"
+)
+
+// pvrFormats lists all supported PVRFormats
+var pvrFormats = [...]PVRFormat{
+	PVRFormatPVRTCII4,
+	PVRFormatPVRTCII2,
+	PVRFormatPVRTC4,
+	PVRFormatPVRTC2,
+	PVRFormatETC1,
+	PVRFormatRGBA8888,
+	PVRFormatRGBA4444,
+	PVRFormatRGB565,
+	PVRFormatRGBA5551,
+	PVRFormatARGB1555,
+}
+
+// PVRFormats returns all supported PVRFormats
+func PVRFormats() []PVRFormat {
+	return append([]PVRFormat(nil), pvrFormats[:]...)
+}
+
+// pvrUncompressedPixelTypes maps uncompressed PVRFormats to their pixel types
+var pvrUncompressedPixelTypes = map[PVRFormat]uint32{
+	PVRFormatRGBA8888: PVR_OGL_RGBA_8888,
+	PVRFormatRGBA4444: PVR_OGL_RGBA_4444,
+	PVRFormatRGB565:   PVR_OGL_RGB_565,
+	PVRFormatRGBA5551: PVR_OGL_RGBA_5551,
+	PVRFormatARGB1555: PVR_MGL_ARGB_1555,
+}
+
+// BakeOptions holds the settings used to create and encode MTX files.
+// Options are passed along with every call, so different settings can be used concurrently.
+// The zero value of any field selects its default
+type BakeOptions struct {
+	JPEGQuality int
+	Filter      string        // name of the filter used to generate smaller tiers, see ResampleFilterNames
+	Tiers       int           // number of tiers to generate
+	Bleed       BleedStrategy // fills the colors of invisible pixels in MTXv1 files
+	LinearLight bool          // resamples smaller tiers in linear light instead of sRGB
+	Sharpen     float64       // sigma of an unsharp mask applied to smaller tiers. 0 disables it
+	OddSize     OddSizePolicy // how smaller tiers are sized if the image's sides can't be halved exactly
+
+	// SmallJPEGQuality is the JPEG quality of smaller tiers. 0 uses JPEGQuality for them as well
+	SmallJPEGQuality int
+	// ZlibLevel is the compression level of MTXv1 masks. nil selects zlib.BestCompression,
+	// since 0 is zlib.NoCompression
+	ZlibLevel *int
+
+	// Limits bounds the size of the images and files being created. nil selects DefaultLimits
+	Limits *Limits
+
+	// PVRFormat selects the pixel format of MTXv2 textures created from images
+	PVRFormat PVRFormat
+	// PVRTCIterative enables the slower, higher quality PVRTC compression mode
+	PVRTCIterative bool
+	// PadPVRTC pads images to a square with power-of-two sides, as PVRTC1 requires, instead of rejecting them
+	PadPVRTC bool
+	// TwiddlePVR stores uncompressed MTXv2 textures in twiddled order. PVRTC1 textures are always twiddled, PVRTC-II textures never are
+	TwiddlePVR bool
+
+	// ReencodeJPEG disables embedding JPEG input files as they are. See NewFileFromJPEG
+	ReencodeJPEG bool
+
+	// DryRun and OutputPath are used by WriteFile
+	DryRun     bool
+	OutputPath string
+}
+
+// DefaultBakeOptions returns the settings used when no options are given
+func DefaultBakeOptions() *BakeOptions {
+	zlibLevel := zlib.BestCompression
+	return &BakeOptions{
+		JPEGQuality: DefaultJPEGQuality,
+		ZlibLevel:   &zlibLevel,
+		Filter:      DefaultFilter,
+		Tiers:       DefaultTierCount,
+		Bleed:       DefaultBleed,
+		OddSize:     DefaultOddSize,
+		PVRFormat:   DefaultPVRFormat,
+		Limits:      DefaultLimits(),
+	}
+}
+
+// withDefaults returns a copy of o with all unset fields replaced by their defaults. o may be nil
+func (o *BakeOptions) withDefaults() *BakeOptions {
+	defaults := DefaultBakeOptions()
+	if o == nil {
+		return defaults
+	}
+
+	opts := *o
+	if opts.JPEGQuality == 0 {
+		opts.JPEGQuality = defaults.JPEGQuality
+	}
+	if opts.ZlibLevel == nil {
+		opts.ZlibLevel = defaults.ZlibLevel
+	}
+	if opts.Filter == "" {
+		opts.Filter = defaults.Filter
+	}
+	if opts.Tiers == 0 {
+		opts.Tiers = defaults.Tiers
+	}
+	if opts.Bleed == "" {
+		opts.Bleed = defaults.Bleed
+	}
+	if opts.OddSize == "" {
+		opts.OddSize = defaults.OddSize
+	}
+	if opts.PVRFormat == "" {
+		opts.PVRFormat = defaults.PVRFormat
+	}
+	opts.Limits = opts.Limits.withDefaults()
+
+	return &opts
+}
+
+func (o *BakeOptions) jpegOptions() *jpeg.Options {
+	return &jpeg.Options{Quality: o.JPEGQuality}
+}
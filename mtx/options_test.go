@@ -0,0 +1,57 @@
+package mtx
+
+import (
+	"compress/zlib"
+	"testing"
+)
+
+func TestOptionListsAreCopies(t *testing.T) {
+	PVRFormats()[0] = "changed"
+	BleedStrategies()[0] = "changed"
+	OddSizePolicies()[0] = "changed"
+	ResampleFilterNames()[0] = "changed"
+
+	if PVRFormats()[0] != PVRFormatPVRTCII4 || BleedStrategies()[0] != BleedDilate || OddSizePolicies()[0] != OddSizeRoundDown {
+		t.Error("changing a returned list changed the supported options")
+	}
+	if _, ok := ResampleFilter(ResampleFilterNames()[0]); !ok {
+		t.Error("changing the returned filter names changed the supported filters")
+	}
+}
+
+func TestWithDefaultsKeepsZeroLikeSettings(t *testing.T) {
+	noCompression := zlib.NoCompression
+	opts := (&BakeOptions{Filter: "nearest", ZlibLevel: &noCompression}).withDefaults()
+	if opts.Filter != "nearest" || *opts.ZlibLevel != zlib.NoCompression {
+		t.Errorf("got filter %q and zlib level %d, want nearest and %d", opts.Filter, *opts.ZlibLevel, zlib.NoCompression)
+	}
+
+	opts = (&BakeOptions{}).withDefaults()
+	if opts.Filter != DefaultFilter || *opts.ZlibLevel != zlib.BestCompression {
+		t.Errorf("got filter %q and zlib level %d, want the defaults", opts.Filter, *opts.ZlibLevel)
+	}
+}
+
+func TestNearestFilterIsUsed(t *testing.T) {
+	img := testImage()
+	nearest, err := NewFile(0, img, &BakeOptions{Filter: "nearest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every pixel of the smaller tier needs to be copied from the image
+	small := nearest.Tiers[0].Image()
+	for y := 0; y < small.Rect.Dy(); y++ {
+		for x := 0; x < small.Rect.Dx(); x++ {
+			if got, want := small.NRGBAAt(x, y), img.NRGBAAt(x*2+1, y*2+1); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestUnknownFilterIsRejected(t *testing.T) {
+	if _, err := NewFile(0, testImage(), &BakeOptions{Filter: "unknown"}); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,497 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pixel types and flags stored in the PixelFormatFlags field of legacy PVR headers
+const (
+	PVR_PIXEL_TYPE_MASK = 0xFF
+
+	PVR_MGL_ARGB_4444 = 0x00
+	PVR_MGL_ARGB_1555 = 0x01
+	PVR_MGL_RGB_565   = 0x02
+	PVR_MGL_RGB_555   = 0x03
+	PVR_MGL_RGB_888   = 0x04
+	PVR_MGL_ARGB_8888 = 0x05
+	PVR_MGL_I_8       = 0x07
+	PVR_MGL_AI_88     = 0x08
+	PVR_MGL_PVRTC2    = 0x0C
+	PVR_MGL_PVRTC4    = 0x0D
+
+	PVR_OGL_RGBA_4444 = 0x10
+	PVR_OGL_RGBA_5551 = 0x11
+	PVR_OGL_RGBA_8888 = 0x12
+	PVR_OGL_RGB_565   = 0x13
+	PVR_OGL_RGB_555   = 0x14
+	PVR_OGL_RGB_888   = 0x15
+	PVR_OGL_I_8       = 0x16
+	PVR_OGL_AI_88     = 0x17
+	PVR_OGL_PVRTC2    = 0x18
+	PVR_OGL_PVRTC4    = 0x19
+	PVR_OGL_BGRA_8888 = 0x1A
+	PVR_OGL_A_8       = 0x1B
+	PVR_OGL_PVRTCII4  = 0x1C
+	PVR_OGL_PVRTCII2  = 0x1D
+
+	PVR_ETC_RGB_4BPP = 0x36
+
+	PVR_FLAG_MIPMAP        = 0x100
+	PVR_FLAG_TWIDDLE       = 0x200
+	PVR_FLAG_BUMPMAP       = 0x400
+	PVR_FLAG_TILING        = 0x800
+	PVR_FLAG_CUBEMAP       = 0x1000
+	PVR_FLAG_FALSE_MIPCOL  = 0x2000
+	PVR_FLAG_VOLUME        = 0x4000
+	PVR_FLAG_ALPHA         = 0x8000
+	PVR_FLAG_VERTICAL_FLIP = 0x10000
+)
+
+var (
+	pvrPixelTypeNames = map[uint32]string{
+		PVR_MGL_ARGB_4444: "ARGB_4444",
+		PVR_MGL_ARGB_1555: "ARGB_1555",
+		PVR_MGL_RGB_565:   "RGB_565",
+		PVR_MGL_RGB_555:   "RGB_555",
+		PVR_MGL_RGB_888:   "RGB_888",
+		PVR_MGL_ARGB_8888: "ARGB_8888",
+		PVR_MGL_I_8:       "I_8",
+		PVR_MGL_AI_88:     "AI_88",
+		PVR_MGL_PVRTC2:    "PVRTC2",
+		PVR_MGL_PVRTC4:    "PVRTC4",
+		PVR_OGL_RGBA_4444: "OGL_RGBA_4444",
+		PVR_OGL_RGBA_5551: "OGL_RGBA_5551",
+		PVR_OGL_RGBA_8888: "OGL_RGBA_8888",
+		PVR_OGL_RGB_565:   "OGL_RGB_565",
+		PVR_OGL_RGB_555:   "OGL_RGB_555",
+		PVR_OGL_RGB_888:   "OGL_RGB_888",
+		PVR_OGL_I_8:       "OGL_I_8",
+		PVR_OGL_AI_88:     "OGL_AI_88",
+		PVR_OGL_PVRTC2:    "OGL_PVRTC2",
+		PVR_OGL_PVRTC4:    "OGL_PVRTC4",
+		PVR_OGL_BGRA_8888: "OGL_BGRA_8888",
+		PVR_OGL_A_8:       "OGL_A_8",
+		PVR_OGL_PVRTCII4:  "OGL_PVRTCII4",
+		PVR_OGL_PVRTCII2:  "OGL_PVRTCII2",
+		PVR_ETC_RGB_4BPP:  "ETC_RGB_4BPP",
+	}
+
+	pvrFlagNames = []struct {
+		flag uint32
+		name string
+	}{
+		{PVR_FLAG_MIPMAP, "mipmap"},
+		{PVR_FLAG_TWIDDLE, "twiddle"},
+		{PVR_FLAG_BUMPMAP, "bumpmap"},
+		{PVR_FLAG_TILING, "tiling"},
+		{PVR_FLAG_CUBEMAP, "cubemap"},
+		{PVR_FLAG_FALSE_MIPCOL, "falseMipCol"},
+		{PVR_FLAG_VOLUME, "volume"},
+		{PVR_FLAG_ALPHA, "alpha"},
+		{PVR_FLAG_VERTICAL_FLIP, "verticalFlip"},
+	}
+)
+
+// PixelType returns the pixel type stored in the header's PixelFormatFlags
+func (h PVRTC2Header) PixelType() uint32 {
+	return h.PixelFormatFlags & PVR_PIXEL_TYPE_MASK
+}
+
+// PixelTypeName returns a readable name for the header's pixel type
+func (h PVRTC2Header) PixelTypeName() string {
+	if name, ok := pvrPixelTypeNames[h.PixelType()]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (0x%02X)", h.PixelType())
+}
+
+// FlagNames returns readable names for the flags set in the header's PixelFormatFlags
+func (h PVRTC2Header) FlagNames() []string {
+	names := []string{}
+	for _, f := range pvrFlagNames {
+		if h.PixelFormatFlags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+
+	return names
+}
+
+// levelSize returns the number of bytes an image of the given size takes up in the header's pixel format.
+// ok is false for pixel types whose size can't be determined
+func (h PVRTC2Header) levelSize(width int, height int) (size int, ok bool) {
+	switch h.PixelType() {
+	case PVR_MGL_PVRTC4, PVR_OGL_PVRTC4:
+		// PVRTC textures are padded to at least two blocks in each direction
+		return atLeast(width, 8) * atLeast(height, 8) / 2, true
+	case PVR_MGL_PVRTC2, PVR_OGL_PVRTC2:
+		return atLeast(width, 16) * atLeast(height, 8) / 4, true
+	case PVR_OGL_PVRTCII4, PVR_ETC_RGB_4BPP:
+		return ceilDiv(width, 4) * ceilDiv(height, 4) * 8, true
+	case PVR_OGL_PVRTCII2:
+		return ceilDiv(width, 8) * ceilDiv(height, 4) * 8, true
+	}
+
+	if masks, ok := h.bitMasks(); ok {
+		return ceilDiv(width*height*masks.bitCount, 8), true
+	}
+
+	return 0, false
+}
+
+// PVRLevel describes where a single mip level of one of a legacy PVR texture's surfaces is stored
+type PVRLevel struct {
+	Surface int `json:"surface"`
+	Level   int `json:"level"` // 0 is the largest level
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+	Offset  int `json:"offset"` // offset within the texture data
+	Size    int `json:"size"`
+}
+
+// maxMipLevels returns the number of mip levels a texture of the given size can have, including the largest one
+func maxMipLevels(width int, height int) int {
+	if width < height {
+		width = height
+	}
+
+	return atLeast(bits.Len(uint(width)), 1)
+}
+
+// checkTextureLayout makes sure a texture of the given size can have the given number of mip levels, including the largest one,
+// and the given number of surfaces with the given number of cube map faces each. Every face takes up at least one byte,
+// so a texture can't have more of them than available bytes of data. This bounds the memory needed to list its levels
+func checkTextureLayout(width int, height int, levels int, surfaces int, faces int, available int, limits *Limits) error {
+	surfaces, faces = atLeast(surfaces, 1), atLeast(faces, 1)
+	if maxLevels := maxMipLevels(width, height); levels > maxLevels {
+		return fmt.Errorf("%d mip levels are too many for a %dx%d texture, which can have at most %d", levels, width, height, maxLevels)
+	} else if faces != 1 && faces != 6 {
+		return fmt.Errorf("textures with %d faces are unsupported", faces)
+	} else if err := limits.CheckSurfaces(surfaces * faces); err != nil {
+		return err
+	} else if surfaces*faces > available {
+		return fmt.Errorf("%d surfaces don't fit into the %d bytes of data left: %w", surfaces*faces, available, ErrTruncated)
+	}
+
+	return nil
+}
+
+// checkLayout makes sure the header's mip level and surface counts fit its dimensions and the available bytes of data.
+// Unlike decodePVR, it applies no limits, as the surfaces of textures that were read have been counted already
+func (h PVRTC2Header) checkLayout(available int) error {
+	return checkTextureLayout(int(h.Width), int(h.Height), int(h.MipMapCount)+1, int(h.NumSurfaces), 1, available, &Limits{MaxSurfaces: -1})
+}
+
+// Levels returns the layout of every mip level of every surface, in the order they're stored.
+// Each surface is stored with all of its mip levels, from largest to smallest, before the next surface.
+// Mip levels beyond the 1x1 one are ignored. ok is false for pixel types whose size can't be determined.
+// Headers read by Decode and DecodePVR have been checked to have a plausible number of surfaces
+func (h PVRTC2Header) Levels() (levels []PVRLevel, ok bool) {
+	mipMapCount := int(h.MipMapCount)
+	if maxLevels := maxMipLevels(int(h.Width), int(h.Height)); mipMapCount >= maxLevels {
+		mipMapCount = maxLevels - 1
+	}
+
+	offset := 0
+	for surface := 0; surface < atLeast(int(h.NumSurfaces), 1); surface++ {
+		width, height := int(h.Width), int(h.Height)
+		for level := 0; level <= mipMapCount; level++ {
+			size, ok := h.levelSize(width, height)
+			if !ok {
+				return nil, false
+			}
+
+			levels = append(levels, PVRLevel{surface, level, width, height, offset, size})
+			offset += size
+			width, height = atLeast(width/2, 1), atLeast(height/2, 1)
+		}
+	}
+
+	return levels, true
+}
+
+// ExpectedDataSize returns the data size implied by the header's dimensions, pixel type, mip map count and surface count.
+// ok is false for pixel types whose size can't be determined
+func (h PVRTC2Header) ExpectedDataSize() (size int, ok bool) {
+	levels, ok := h.Levels()
+	for _, level := range levels {
+		size += level.Size
+	}
+
+	return size, ok
+}
+
+func decodePVR(r *mtxReader) (PVRTC2Header, []byte, error) {
+	headerOffset := r.offset()
+	if err := r.need("PVR header", PVRTC2_HEADER_SIZE); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+	header, err := readPVRTC2Header(r)
+	if err != nil {
+		return header, nil, err
+	}
+
+	// make sure the PVR file uses a known format
+	if string(header.Magic[:]) != "PVR!" {
+		return header, nil, r.errorAt(headerOffset+44, fmt.Sprintf("PVR magic %q", header.Magic[:]), ErrBadPVRMagic)
+	} else if header.HeaderSize != PVRTC2_HEADER_SIZE {
+		return header, nil, r.errorAt(headerOffset, "PVR header size", fmt.Errorf("%d is unsupported", header.HeaderSize))
+	} else if err := r.limits.CheckImage(int(header.Width), int(header.Height)); err != nil {
+		return header, nil, r.errorAt(headerOffset+4, "PVR dimensions", err)
+	} else if err := checkTextureLayout(int(header.Width), int(header.Height), int(header.MipMapCount)+1, int(header.NumSurfaces), 1, r.Len(), r.limits); err != nil {
+		return header, nil, r.errorAt(headerOffset+12, "PVR layout", err)
+	} else if err := header.checkBitMasks(); err != nil {
+		return header, nil, r.errorAt(headerOffset+24, "PVR bit count", err)
+	}
+
+	data, err := r.readField("PVR data", int64(header.CompressedDataSize))
+	if err != nil {
+		return header, nil, err
+	}
+
+	if expected, ok := header.ExpectedDataSize(); ok && expected != len(data) {
+		log.Warnf("The PVR data size of %d bytes doesn't match the %d bytes expected for the texture's mip levels and surfaces", len(data), expected)
+	}
+
+	return header, data, nil
+}
+
+// DecodePVR reads a legacy PVR texture from r using the default limits and returns its header and payload.
+// PVR v3, KTX, KTX 2 and DDS textures are converted to the legacy layout. Problems with the texture's structure are reported as *ParseError
+func DecodePVR(r io.Reader) (PVRTC2Header, []byte, error) {
+	return DecodePVRWithLimits(r, nil)
+}
+
+// DecodePVRWithLimits reads a legacy PVR texture from r like DecodePVR, but rejects textures exceeding limits, which may be nil
+func DecodePVRWithLimits(r io.Reader, limits *Limits) (PVRTC2Header, []byte, error) {
+	data, err := readInput(r, limits)
+	if err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	switch {
+	case isPVR3(data):
+		return decodePVR3(newMTXReader(data, limits))
+	case isKTX(data):
+		return decodeKTX(newMTXReader(data, limits))
+	case isKTX2(data):
+		return decodeKTX2(newMTXReader(data, limits))
+	case isDDS(data):
+		return decodeDDS(newMTXReader(data, limits))
+	}
+
+	pvrReader := newMTXReader(data, limits)
+	header, pvrData, err := decodePVR(pvrReader)
+	if err == nil && pvrReader.Len() > 0 {
+		log.Warnf("Ignoring %d bytes after the PVR data", pvrReader.Len())
+	}
+
+	return header, pvrData, err
+}
+
+// checkPVR makes sure a legacy PVR texture consisting of header and data can be read back by decodePVR
+// with the given limits, which may be nil, and holds enough data for its dimensions, pixel type, mip levels and surfaces.
+// Like decodePVR, it warns about surplus data
+func checkPVR(header PVRTC2Header, data []byte, limits *Limits) error {
+	if string(header.Magic[:]) != "PVR!" {
+		return fmt.Errorf("PVR magic %q %v", header.Magic[:], ErrBadPVRMagic)
+	} else if header.HeaderSize != PVRTC2_HEADER_SIZE {
+		return fmt.Errorf("a PVR header size of %d is unsupported", header.HeaderSize)
+	} else if header.Width == 0 || header.Height == 0 {
+		return fmt.Errorf("PVR textures of %dx%d pixels are empty", header.Width, header.Height)
+	} else if err := checkTextureLayout(int(header.Width), int(header.Height), int(header.MipMapCount)+1, int(header.NumSurfaces), 1, len(data), limits); err != nil {
+		return err
+	} else if err := header.checkBitMasks(); err != nil {
+		return err
+	} else if int(header.CompressedDataSize) != len(data) {
+		return fmt.Errorf("the PVR header's data size of %d bytes doesn't match the %d bytes of PVR data", header.CompressedDataSize, len(data))
+	}
+
+	expected, ok := header.ExpectedDataSize()
+	if !ok {
+		log.Warnf("The size of %s textures is unknown, so the PVR data can't be checked", header.PixelTypeName())
+	} else if len(data) < expected {
+		return fmt.Errorf("the %d bytes of PVR data are too short for the %dx%d %s texture with %d mip level(s) and %d surface(s), expected %d",
+			len(data), header.Width, header.Height, header.PixelTypeName(), header.MipMapCount+1, atLeast(int(header.NumSurfaces), 1), expected)
+	} else if len(data) > expected {
+		log.Warnf("The PVR data size of %d bytes doesn't match the %d bytes expected for the texture's mip levels and surfaces", len(data), expected)
+	}
+
+	return nil
+}
+
+// DecodePVRImage decodes the first surface of the largest mip level of a legacy PVR texture
+func DecodePVRImage(header PVRTC2Header, data []byte) (*image.NRGBA, error) {
+	levels, ok := header.Levels()
+	if !ok {
+		return nil, fmt.Errorf("decoding %s textures is unsupported", header.PixelTypeName())
+	}
+
+	return DecodePVRLevel(header, data, levels[0])
+}
+
+// DecodePVRLevel decodes a single mip level of a legacy PVR texture, as returned by header.Levels
+func DecodePVRLevel(header PVRTC2Header, data []byte, level PVRLevel) (*image.NRGBA, error) {
+	if level.Offset+level.Size > len(data) {
+		return nil, fmt.Errorf("mip level %d of surface %d is missing from the PVR data", level.Level, level.Surface)
+	}
+	data = data[level.Offset : level.Offset+level.Size]
+	width, height := level.Width, level.Height
+
+	var img *image.NRGBA
+	var err error
+	switch header.PixelType() {
+	case PVR_MGL_PVRTC4, PVR_OGL_PVRTC4:
+		img, err = decodePVRTC(data, width, height, false, false)
+	case PVR_MGL_PVRTC2, PVR_OGL_PVRTC2:
+		img, err = decodePVRTC(data, width, height, true, false)
+	case PVR_OGL_PVRTCII4:
+		img, err = decodePVRTC(data, width, height, false, true)
+	case PVR_OGL_PVRTCII2:
+		img, err = decodePVRTC(data, width, height, true, true)
+	case PVR_ETC_RGB_4BPP:
+		img, err = decodeETC1(data, width, height)
+	default:
+		masks, ok := header.bitMasks()
+		if !ok {
+			return nil, fmt.Errorf("decoding %s textures is unsupported", header.PixelTypeName())
+		}
+
+		if header.PixelFormatFlags&PVR_FLAG_TWIDDLE != 0 {
+			if data, err = reorderTwiddled(data, width, height, masks.bitCount/8, false); err != nil {
+				return nil, err
+			}
+		}
+
+		img, err = decodeUncompressed(data, width, height, masks)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if header.PixelFormatFlags&PVR_FLAG_VERTICAL_FLIP != 0 {
+		img = imaging.FlipV(img)
+	}
+
+	return img, nil
+}
+
+// EncodePVRImage creates a legacy PVR texture from img in the pixel format selected by opts, which may be nil
+func EncodePVRImage(img image.Image, opts *BakeOptions) (PVRTC2Header, []byte, error) {
+	opts = opts.withDefaults()
+	nrgba := imageToNRGBA(img)
+
+	header := PVRTC2Header{
+		HeaderSize:  PVRTC2_HEADER_SIZE,
+		Magic:       FourCC{'P', 'V', 'R', '!'},
+		NumSurfaces: 1,
+	}
+
+	var data []byte
+	var err error
+	storesAlpha := true
+	switch opts.PVRFormat {
+	case PVRFormatPVRTCII4, PVRFormatPVRTCII2, PVRFormatPVRTC4, PVRFormatPVRTC2:
+		twoBPP := opts.PVRFormat == PVRFormatPVRTCII2 || opts.PVRFormat == PVRFormatPVRTC2
+		pvrtcII := opts.PVRFormat == PVRFormatPVRTCII4 || opts.PVRFormat == PVRFormatPVRTCII2
+		// unlike PVRTC1 textures, PVRTC-II textures can have any size, like the games' own 960x540 ones
+		if !pvrtcII {
+			if nrgba, err = fitPVRTC(nrgba, twoBPP, opts.PadPVRTC); err != nil {
+				return header, nil, err
+			}
+		}
+
+		switch {
+		case pvrtcII && twoBPP:
+			header.PixelFormatFlags, header.BitCount = PVR_OGL_PVRTCII2, 2
+		case pvrtcII:
+			header.PixelFormatFlags, header.BitCount = PVR_OGL_PVRTCII4, 4
+		case twoBPP:
+			header.PixelFormatFlags, header.BitCount = PVR_OGL_PVRTC2, 2
+		default:
+			header.PixelFormatFlags, header.BitCount = PVR_OGL_PVRTC4, 4
+		}
+
+		data, err = encodePVRTC(nrgba, twoBPP, pvrtcII, opts.PVRTCIterative)
+	case PVRFormatETC1:
+		header.PixelFormatFlags, header.BitCount = PVR_ETC_RGB_4BPP, 4
+		data = encodeETC1(nrgba)
+		storesAlpha = false
+	default:
+		pixelType, ok := pvrUncompressedPixelTypes[opts.PVRFormat]
+		if !ok {
+			return header, nil, fmt.Errorf("unsupported PVR format %q", opts.PVRFormat)
+		}
+
+		masks := pvrDefaultBitMasks[pixelType]
+		header.PixelFormatFlags, header.BitCount = pixelType, uint32(masks.bitCount)
+		header.BitMaskR, header.BitMaskG, header.BitMaskB, header.BitMaskA = masks.r, masks.g, masks.b, masks.a
+		data = encodeUncompressed(nrgba, masks)
+		storesAlpha = masks.a != 0
+
+		if opts.TwiddlePVR {
+			header.PixelFormatFlags |= PVR_FLAG_TWIDDLE
+			data, err = reorderTwiddled(data, nrgba.Rect.Dx(), nrgba.Rect.Dy(), masks.bitCount/8, true)
+		}
+	}
+	if err != nil {
+		return header, nil, err
+	}
+
+	if !nrgba.Opaque() {
+		if storesAlpha {
+			header.PixelFormatFlags |= PVR_FLAG_ALPHA
+		} else {
+			log.Warnf("The image is transparent, but %s textures are always opaque", opts.PVRFormat)
+		}
+	}
+
+	header.Width, header.Height = uint32(nrgba.Rect.Dx()), uint32(nrgba.Rect.Dy())
+	header.CompressedDataSize = uint32(len(data))
+	return header, data, nil
+}
+
+// fitPVRTC makes sure img is a square with power-of-two sides, as PVRTC1 requires.
+// If pad is set, smaller images are enlarged by repeating their right and bottom edges
+func fitPVRTC(img *image.NRGBA, twoBPP bool, pad bool) (*image.NRGBA, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+
+	// PVRTC1 textures consist of at least two blocks in each direction
+	minSize := 8
+	if twoBPP {
+		minSize = 16
+	}
+
+	size := minSize
+	for size < width || size < height {
+		size *= 2
+	}
+
+	if width == size && height == size {
+		return img, nil
+	} else if !pad {
+		return nil, fmt.Errorf("PVRTC textures need to be square with power-of-two sides of at least %d pixels, but the image is %dx%d. Enable padding to enlarge it to %dx%d", minSize, width, height, size, size)
+	}
+
+	log.Warnf("Padding the %dx%d image to %dx%d. Only the top left part of the texture will hold the image", width, height, size, size)
+
+	return padImage(img, size, size), nil
+}
+
+// EncodePVR writes a legacy PVR texture consisting of header and data to w
+func EncodePVR(w io.Writer, header PVRTC2Header, data []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,291 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+PVR v3 files store the same kind of texture data as legacy PVR files, but order it differently:
+legacy files store every surface with all of its mip levels one after the other, PVR v3 files store
+every mip level of all surfaces and faces one after the other. Cube maps are stored as six surfaces
+in legacy files and as six faces in PVR v3 files
+*/
+
+const (
+	PVR3_VERSION = 0x03525650 // "PVR\x03"
+
+	// compressed PVR v3 pixel formats. Uncompressed formats are described by their channels, see pvr3Format
+	PVR3_PVRTC_2BPP_RGB  = 0
+	PVR3_PVRTC_2BPP_RGBA = 1
+	PVR3_PVRTC_4BPP_RGB  = 2
+	PVR3_PVRTC_4BPP_RGBA = 3
+	PVR3_PVRTCII_2BPP    = 4
+	PVR3_PVRTCII_4BPP    = 5
+	PVR3_ETC1            = 6
+
+	PVR3_CHANNEL_TYPE_UNSIGNED_BYTE_NORM = 0
+
+	PVR3_METADATA_ORIENTATION = 3
+	PVR3_ORIENTATION_UP       = 2 // the y axis of the texture points up, so its rows are stored bottom to top
+)
+
+// pvr3Format returns the uncompressed PVR v3 pixel format made up of the given channels, e.g. "rgba", and their bit counts
+func pvr3Format(channels string, bitCounts ...int) uint64 {
+	var format uint64
+	for i := range channels {
+		format |= uint64(channels[i])<<(8*i) | uint64(bitCounts[i])<<(32+8*i)
+	}
+
+	return format
+}
+
+// pvr3PixelFormats pairs legacy pixel types with their PVR v3 equivalents. Legacy PVRTC1 textures
+// with the alpha flag set use the RGBA variants of the PVR v3 formats. When converting PVR v3 textures,
+// the first matching legacy pixel type is used
+var pvr3PixelFormats = []struct {
+	legacy  uint32
+	v3      uint64
+	v3Alpha uint64
+}{
+	{PVR_OGL_PVRTC2, PVR3_PVRTC_2BPP_RGB, PVR3_PVRTC_2BPP_RGBA},
+	{PVR_OGL_PVRTC4, PVR3_PVRTC_4BPP_RGB, PVR3_PVRTC_4BPP_RGBA},
+	{PVR_MGL_PVRTC2, PVR3_PVRTC_2BPP_RGB, PVR3_PVRTC_2BPP_RGBA},
+	{PVR_MGL_PVRTC4, PVR3_PVRTC_4BPP_RGB, PVR3_PVRTC_4BPP_RGBA},
+	{PVR_OGL_PVRTCII2, PVR3_PVRTCII_2BPP, PVR3_PVRTCII_2BPP},
+	{PVR_OGL_PVRTCII4, PVR3_PVRTCII_4BPP, PVR3_PVRTCII_4BPP},
+	{PVR_ETC_RGB_4BPP, PVR3_ETC1, PVR3_ETC1},
+	{PVR_OGL_RGBA_8888, pvr3Format("rgba", 8, 8, 8, 8), pvr3Format("rgba", 8, 8, 8, 8)},
+	{PVR_OGL_BGRA_8888, pvr3Format("bgra", 8, 8, 8, 8), pvr3Format("bgra", 8, 8, 8, 8)},
+	{PVR_MGL_ARGB_8888, pvr3Format("bgra", 8, 8, 8, 8), pvr3Format("bgra", 8, 8, 8, 8)},
+	{PVR_OGL_RGB_888, pvr3Format("rgb", 8, 8, 8), pvr3Format("rgb", 8, 8, 8)},
+	{PVR_MGL_RGB_888, pvr3Format("bgr", 8, 8, 8), pvr3Format("bgr", 8, 8, 8)},
+	{PVR_OGL_RGBA_4444, pvr3Format("rgba", 4, 4, 4, 4), pvr3Format("rgba", 4, 4, 4, 4)},
+	{PVR_MGL_ARGB_4444, pvr3Format("argb", 4, 4, 4, 4), pvr3Format("argb", 4, 4, 4, 4)},
+	{PVR_OGL_RGBA_5551, pvr3Format("rgba", 5, 5, 5, 1), pvr3Format("rgba", 5, 5, 5, 1)},
+	{PVR_MGL_ARGB_1555, pvr3Format("argb", 1, 5, 5, 5), pvr3Format("argb", 1, 5, 5, 5)},
+	{PVR_OGL_RGB_565, pvr3Format("rgb", 5, 6, 5), pvr3Format("rgb", 5, 6, 5)},
+	{PVR_MGL_RGB_565, pvr3Format("rgb", 5, 6, 5), pvr3Format("rgb", 5, 6, 5)},
+	{PVR_OGL_I_8, pvr3Format("l", 8), pvr3Format("l", 8)},
+	{PVR_MGL_I_8, pvr3Format("l", 8), pvr3Format("l", 8)},
+	{PVR_OGL_AI_88, pvr3Format("la", 8, 8), pvr3Format("la", 8, 8)},
+	{PVR_MGL_AI_88, pvr3Format("la", 8, 8), pvr3Format("la", 8, 8)},
+	{PVR_OGL_A_8, pvr3Format("a", 8), pvr3Format("a", 8)},
+}
+
+// pvr3Layout returns the sizes of the texture's mip levels, from largest to smallest, and the number of
+// surfaces stored per mip level, counting every face of a cube map as a separate surface.
+// available is the size of the texture's data
+func pvr3Layout(h PVRTC2Header, available int) ([]int, int, error) {
+	if err := h.checkLayout(available); err != nil {
+		return nil, 0, err
+	}
+	levels, ok := h.Levels()
+	if !ok {
+		return nil, 0, fmt.Errorf("the size of %s textures is unknown", h.PixelTypeName())
+	}
+
+	levelSizes := make([]int, len(levels)/atLeast(int(h.NumSurfaces), 1))
+	for i := range levelSizes {
+		levelSizes[i] = levels[i].Size
+	}
+
+	return levelSizes, atLeast(int(h.NumSurfaces), 1), nil
+}
+
+// reorderSurfaces converts texture data between the legacy order and the level-major order used by PVR v3 and KTX files.
+// If levelMajor is set, data is converted from the legacy order, otherwise to the legacy order
+func reorderSurfaces(data []byte, levelSizes []int, surfaces int, levelMajor bool) []byte {
+	surfaceSize := 0
+	for _, size := range levelSizes {
+		surfaceSize += size
+	}
+
+	reordered := make([]byte, surfaceSize*surfaces)
+	levelOffset := 0
+	for _, size := range levelSizes {
+		for surface := 0; surface < surfaces; surface++ {
+			legacyOffset := surface*surfaceSize + levelOffset
+			levelMajorOffset := levelOffset*surfaces + surface*size
+
+			if levelMajor {
+				copy(reordered[levelMajorOffset:levelMajorOffset+size], data[legacyOffset:])
+			} else {
+				copy(reordered[legacyOffset:legacyOffset+size], data[levelMajorOffset:])
+			}
+		}
+		levelOffset += size
+	}
+
+	return reordered
+}
+
+// pvr3FlippedVertically reports whether the metadata of a PVR v3 file marks the texture as stored bottom to top
+func pvr3FlippedVertically(metadata []byte) bool {
+	for len(metadata) >= 12 {
+		fourCC := binary.LittleEndian.Uint32(metadata)
+		key := binary.LittleEndian.Uint32(metadata[4:])
+		size := int(binary.LittleEndian.Uint32(metadata[8:]))
+		metadata = metadata[12:]
+		if size > len(metadata) {
+			break
+		}
+
+		if fourCC == PVR3_VERSION && key == PVR3_METADATA_ORIENTATION && size >= 2 {
+			return metadata[1]&PVR3_ORIENTATION_UP != 0
+		}
+		metadata = metadata[size:]
+	}
+
+	return false
+}
+
+// pvr3ToLegacy converts a PVR v3 texture to a legacy PVR texture. data needs to hold every surface and mip level
+func pvr3ToLegacy(h PVR3Header, metadata []byte, data []byte) (PVRTC2Header, []byte, error) {
+	pixelType, alpha, found := uint32(0), false, false
+	for _, f := range pvr3PixelFormats {
+		if h.PixelFormat == f.v3 || h.PixelFormat == f.v3Alpha {
+			pixelType, alpha, found = f.legacy, h.PixelFormat == f.v3Alpha && f.v3Alpha != f.v3, true
+			break
+		}
+	}
+	if !found {
+		return PVRTC2Header{}, nil, fmt.Errorf("PVR v3 pixel format 0x%016X has no legacy equivalent", h.PixelFormat)
+	}
+
+	if _, uncompressed := pvrDefaultBitMasks[pixelType]; uncompressed && h.ChannelType != PVR3_CHANNEL_TYPE_UNSIGNED_BYTE_NORM {
+		return PVRTC2Header{}, nil, fmt.Errorf("PVR v3 channel type %d is unsupported", h.ChannelType)
+	} else if h.Depth > 1 {
+		return PVRTC2Header{}, nil, errors.New("volume textures are unsupported")
+	}
+
+	header, err := newLegacyPVRHeader(pixelType, int(h.Width), int(h.Height), int(h.MIPMapCount), int(h.NumSurfaces), int(h.NumFaces))
+	if err != nil {
+		return header, nil, err
+	}
+
+	if alpha {
+		header.PixelFormatFlags |= PVR_FLAG_ALPHA
+	}
+	if pvr3FlippedVertically(metadata) {
+		header.PixelFormatFlags |= PVR_FLAG_VERTICAL_FLIP
+	}
+
+	legacyData, err := fromLevelMajor(header, data)
+	return header, legacyData, err
+}
+
+// legacyToPVR3 converts a legacy PVR texture to a PVR v3 texture and its metadata
+func legacyToPVR3(h PVRTC2Header, data []byte) (PVR3Header, []byte, []byte, error) {
+	header := PVR3Header{
+		Version:     PVR3_VERSION,
+		ChannelType: PVR3_CHANNEL_TYPE_UNSIGNED_BYTE_NORM,
+		Height:      h.Height,
+		Width:       h.Width,
+		Depth:       1,
+		NumSurfaces: uint32(atLeast(int(h.NumSurfaces), 1)),
+		NumFaces:    1,
+		MIPMapCount: h.MipMapCount + 1,
+	}
+
+	found := false
+	for _, f := range pvr3PixelFormats {
+		if h.PixelType() == f.legacy {
+			header.PixelFormat = f.v3
+			if h.PixelFormatFlags&PVR_FLAG_ALPHA != 0 {
+				header.PixelFormat = f.v3Alpha
+			}
+
+			found = true
+			break
+		}
+	}
+	if !found {
+		return header, nil, nil, fmt.Errorf("%s textures can't be converted to PVR v3", h.PixelTypeName())
+	}
+
+	if h.PixelFormatFlags&PVR_FLAG_CUBEMAP != 0 && header.NumSurfaces%6 == 0 {
+		header.NumFaces = 6
+		header.NumSurfaces /= 6
+	}
+
+	pvr3Data, err := toLevelMajor(h, data)
+	if err != nil {
+		return header, nil, nil, err
+	}
+
+	var metadata []byte
+	if h.PixelFormatFlags&PVR_FLAG_VERTICAL_FLIP != 0 {
+		metadata = make([]byte, 15)
+		binary.LittleEndian.PutUint32(metadata, PVR3_VERSION)
+		binary.LittleEndian.PutUint32(metadata[4:], PVR3_METADATA_ORIENTATION)
+		binary.LittleEndian.PutUint32(metadata[8:], 3)
+		metadata[13] = PVR3_ORIENTATION_UP
+	}
+	header.MetaDataSize = uint32(len(metadata))
+
+	return header, metadata, pvr3Data, nil
+}
+
+// isPVR3 checks whether data starts with a PVR v3 header
+func isPVR3(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == PVR3_VERSION
+}
+
+func decodePVR3(r *mtxReader) (PVRTC2Header, []byte, error) {
+	if err := r.need("PVR v3 header", PVR3_HEADER_SIZE); err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	header := PVR3Header{}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return PVRTC2Header{}, nil, err
+	} else if err := r.limits.CheckImage(int(header.Width), int(header.Height)); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(24, "PVR dimensions", err)
+	}
+
+	metadata, err := r.readField("PVR metadata", int64(header.MetaDataSize))
+	if err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	dataOffset := r.offset()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return PVRTC2Header{}, nil, err
+	}
+
+	if err := checkTextureLayout(int(header.Width), int(header.Height), int(header.MIPMapCount),
+		int(header.NumSurfaces), int(header.NumFaces), len(data), r.limits); err != nil {
+		return PVRTC2Header{}, nil, r.errorAt(36, "PVR layout", err)
+	}
+
+	legacyHeader, legacyData, err := pvr3ToLegacy(header, metadata, data)
+	if errors.Is(err, ErrTruncated) {
+		return legacyHeader, nil, r.errorAt(dataOffset, "PVR data", err)
+	}
+
+	return legacyHeader, legacyData, err
+}
+
+// EncodePVR3 converts a legacy PVR texture consisting of header and data to a PVR v3 file and writes it to w.
+// Mip levels, surfaces and cube map faces are preserved
+func EncodePVR3(w io.Writer, header PVRTC2Header, data []byte) error {
+	pvr3Header, metadata, pvr3Data, err := legacyToPVR3(header, data)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, pvr3Header); err != nil {
+		return err
+	}
+	buf.Write(metadata)
+	buf.Write(pvr3Data)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
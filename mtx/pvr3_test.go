@@ -0,0 +1,118 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestPVR3RoundTrip(t *testing.T) {
+	tests := []struct {
+		name                    string
+		pixelType               uint32
+		levels, surfaces, faces int
+		flags                   uint32
+	}{
+		{"rgba8888 with mip levels and surfaces", PVR_OGL_RGBA_8888, 4, 3, 1, 0},
+		{"pvrtc4 cube map with alpha", PVR_OGL_PVRTC4, 2, 1, 6, PVR_FLAG_ALPHA},
+		{"flipped rgb565 surfaces", PVR_OGL_RGB_565, 3, 2, 1, PVR_FLAG_VERTICAL_FLIP},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, err := newLegacyPVRHeader(test.pixelType, 8, 8, test.levels, test.surfaces, test.faces)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header.PixelFormatFlags |= test.flags
+
+			// every byte of the texture is different, so reordering its levels and surfaces shows
+			size, _ := header.ExpectedDataSize()
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i * 7 / 3)
+			}
+			header.CompressedDataSize = uint32(size)
+
+			buf := bytes.Buffer{}
+			if err := EncodePVR3(&buf, header, data); err != nil {
+				t.Fatal(err)
+			}
+
+			pvr3Header := PVR3Header{}
+			if err := binary.Read(bytes.NewReader(buf.Bytes()), binary.LittleEndian, &pvr3Header); err != nil {
+				t.Fatal(err)
+			} else if int(pvr3Header.MIPMapCount) != test.levels || int(pvr3Header.NumSurfaces) != test.surfaces || int(pvr3Header.NumFaces) != test.faces {
+				t.Errorf("got %d mip levels, %d surfaces and %d faces, want %d, %d and %d",
+					pvr3Header.MIPMapCount, pvr3Header.NumSurfaces, pvr3Header.NumFaces, test.levels, test.surfaces, test.faces)
+			}
+
+			gotHeader, gotData, err := DecodePVR(&buf)
+			if err != nil {
+				t.Fatal(err)
+			} else if gotHeader != header {
+				t.Errorf("got header %+v, want %+v", gotHeader, header)
+			} else if !bytes.Equal(gotData, data) {
+				t.Error("the texture data changed")
+			}
+		})
+	}
+}
+
+func TestPVR3RejectsUnsupportedFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		pixelFormat uint64
+		channelType uint32
+	}{
+		{"BC1", 7, PVR3_CHANNEL_TYPE_UNSIGNED_BYTE_NORM},
+		{"16-bit channels", pvr3Format("rgba", 16, 16, 16, 16), PVR3_CHANNEL_TYPE_UNSIGNED_BYTE_NORM},
+		{"float channels", pvr3Format("rgba", 8, 8, 8, 8), 12},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := PVR3Header{
+				Version:     PVR3_VERSION,
+				PixelFormat: test.pixelFormat,
+				ChannelType: test.channelType,
+				Height:      4,
+				Width:       4,
+				Depth:       1,
+				NumSurfaces: 1,
+				NumFaces:    1,
+				MIPMapCount: 1,
+			}
+
+			buf := bytes.Buffer{}
+			binary.Write(&buf, binary.LittleEndian, header)
+			buf.Write(make([]byte, 128))
+
+			if _, _, err := DecodePVR(&buf); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	// legacy pixel types without a PVR v3 equivalent can't be exported either
+	header, err := newLegacyPVRHeader(PVR_OGL_RGB_555, 4, 4, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := EncodePVR3(io.Discard, header, make([]byte, 32)); err == nil {
+		t.Error("exporting OGL_RGB_555: got no error")
+	}
+}
+
+func TestEncodePVR3RejectsTooManyMipLevels(t *testing.T) {
+	// an 8x8 texture has 4 mip levels at most, and the header's data size only covers those
+	header, err := newLegacyPVRHeader(PVR_OGL_RGBA_8888, 8, 8, 4, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.MipMapCount = 6
+
+	if err := EncodePVR3(io.Discard, header, make([]byte, header.CompressedDataSize)); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,203 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// mtxv2File returns an MTXv2 file holding an 8x8 PVRTC 4bpp texture with the given layout and 32 bytes of data
+func mtxv2File(mipMapCount uint32, numSurfaces uint32) []byte {
+	header := PVRTC2Header{
+		HeaderSize:         PVRTC2_HEADER_SIZE,
+		Height:             8,
+		Width:              8,
+		MipMapCount:        mipMapCount,
+		PixelFormatFlags:   PVR_OGL_PVRTC4 | PVR_FLAG_ALPHA,
+		CompressedDataSize: 32,
+		BitCount:           4,
+		Magic:              FourCC{'P', 'V', 'R', '!'},
+		NumSurfaces:        numSurfaces,
+	}
+
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.LittleEndian, HeaderV2{Magic: 2, Unknown: DEFAULT_V2_UNKNOWN})
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(make([]byte, 32))
+	return buf.Bytes()
+}
+
+func TestDecodeRejectsImpossibleLayouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		mipMapCount uint32
+		numSurfaces uint32
+		limits      *Limits
+		want        error
+	}{
+		{"too many mip levels", 0xFFFFFFFF, 1, nil, nil},
+		{"too many surfaces", 0, 0xFFFFFFFF, nil, ErrLimitExceeded},
+		{"surfaces beyond the data", 0, 900, nil, ErrTruncated},
+		{"surfaces beyond the data without limits", 0, 0xFFFFFFFF, &Limits{MaxSurfaces: -1}, ErrTruncated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWithLimits(bytes.NewReader(mtxv2File(test.mipMapCount, test.numSurfaces)), test.limits)
+
+			var parseError *ParseError
+			if !errors.As(err, &parseError) {
+				t.Fatalf("got %v, want a ParseError", err)
+			} else if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAcceptsFullMipChain(t *testing.T) {
+	f, err := Decode(bytes.NewReader(mtxv2File(3, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels, ok := f.PVRHeader.Levels()
+	if !ok || len(levels) != 4 {
+		t.Errorf("got %d levels, want 4", len(levels))
+	}
+}
+
+func TestRejectUnsupportedBitCounts(t *testing.T) {
+	header := PVRTC2Header{
+		HeaderSize:         PVRTC2_HEADER_SIZE,
+		Height:             8,
+		Width:              8,
+		PixelFormatFlags:   PVR_OGL_RGBA_4444,
+		CompressedDataSize: 32,
+		BitCount:           4,
+		BitMaskR:           0x8,
+		BitMaskG:           0x4,
+		BitMaskB:           0x2,
+		BitMaskA:           0x1,
+		Magic:              FourCC{'P', 'V', 'R', '!'},
+		NumSurfaces:        1,
+	}
+
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.LittleEndian, HeaderV2{Magic: 2, Unknown: DEFAULT_V2_UNKNOWN})
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(make([]byte, 32))
+
+	var parseError *ParseError
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); !errors.As(err, &parseError) {
+		t.Errorf("decoding: got %v, want a ParseError", err)
+	}
+
+	if problems, err := Validate(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if len(problems) == 0 {
+		t.Error("validating: got no problems")
+	}
+
+	if err := EncodeKTX2(io.Discard, header, make([]byte, 32)); err == nil {
+		t.Error("encoding KTX 2: got no error")
+	}
+}
+
+func TestCheckPVRWarnsAboutSurplusData(t *testing.T) {
+	header, err := newLegacyPVRHeader(PVR_OGL_PVRTC4, 8, 8, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// collect the warnings logged through the standard logger, leaving its hooks as they were afterwards
+	defer log.StandardLogger().ReplaceHooks(log.StandardLogger().ReplaceHooks(make(log.LevelHooks)))
+	hook := test.NewGlobal()
+
+	for _, size := range []int{32, 40} {
+		header.CompressedDataSize = uint32(size)
+		hook.Reset()
+		if err := checkPVR(header, make([]byte, size), nil); err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || entry.Level == log.WarnLevel
+		}
+		if warned != (size > 32) {
+			t.Errorf("%d bytes: got warned %t, want %t", size, warned, size > 32)
+		}
+	}
+}
+
+func TestEncodeRejectsImpossibleLayouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		levels   int
+		surfaces int
+		isLimit  bool
+	}{
+		{"too many mip levels", 21, 1, false},
+		{"too many surfaces", 1, 2000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, err := newLegacyPVRHeader(PVR_OGL_PVRTCII4, 64, 64, test.levels, test.surfaces, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the data matches the header, whose mip levels are clamped when computing its size
+			size, _ := header.ExpectedDataSize()
+			header.CompressedDataSize = uint32(size)
+
+			err = Encode(io.Discard, NewPVRFile(header, make([]byte, size)), nil)
+			var limitErr *LimitError
+			if err == nil {
+				t.Fatal("got no error")
+			} else if errors.As(err, &limitErr) != test.isLimit {
+				t.Errorf("got %v, want a limit error: %t", err, test.isLimit)
+			}
+		})
+	}
+}
+
+func TestHeaderV2UnknownRoundTrip(t *testing.T) {
+	defer log.StandardLogger().ReplaceHooks(log.StandardLogger().ReplaceHooks(make(log.LevelHooks)))
+	hook := test.NewGlobal()
+
+	for _, unknown := range []uint16{DEFAULT_V2_UNKNOWN, 0, 7, 0xFFFF} {
+		data := mtxv2File(0, 1)
+		binary.LittleEndian.PutUint16(data[4:], unknown)
+
+		hook.Reset()
+		f, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d: %v", unknown, err)
+		} else if f.HeaderV2.Unknown != unknown {
+			t.Errorf("%d: decoded as %d", unknown, f.HeaderV2.Unknown)
+		}
+
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || entry.Level == log.WarnLevel
+		}
+		if warned != (unknown != DEFAULT_V2_UNKNOWN) {
+			t.Errorf("%d: got warned %t, want %t", unknown, warned, unknown != DEFAULT_V2_UNKNOWN)
+		}
+
+		buf := bytes.Buffer{}
+		if err := Encode(&buf, f, nil); err != nil {
+			t.Fatalf("%d: %v", unknown, err)
+		} else if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%d: the re-encoded file differs from the original", unknown)
+		}
+	}
+}
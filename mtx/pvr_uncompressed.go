@@ -0,0 +1,207 @@
+package mtx
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// pvrBitMasks describes where the channels of an uncompressed pixel format are stored.
+// Pixels are read as little-endian integers of bitCount bits
+type pvrBitMasks struct {
+	bitCount   int
+	r, g, b, a uint32
+}
+
+// default masks of the uncompressed pixel types, used when a header doesn't specify any.
+// Intensity formats store the same value in all three color channels
+var pvrDefaultBitMasks = map[uint32]pvrBitMasks{
+	PVR_MGL_ARGB_4444: {16, 0x0F00, 0x00F0, 0x000F, 0xF000},
+	PVR_MGL_ARGB_1555: {16, 0x7C00, 0x03E0, 0x001F, 0x8000},
+	PVR_MGL_RGB_565:   {16, 0xF800, 0x07E0, 0x001F, 0},
+	PVR_MGL_RGB_555:   {16, 0x7C00, 0x03E0, 0x001F, 0},
+	PVR_MGL_RGB_888:   {24, 0xFF0000, 0x00FF00, 0x0000FF, 0},
+	PVR_MGL_ARGB_8888: {32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0xFF000000},
+	PVR_MGL_I_8:       {8, 0xFF, 0xFF, 0xFF, 0},
+	PVR_MGL_AI_88:     {16, 0x00FF, 0x00FF, 0x00FF, 0xFF00},
+	PVR_OGL_RGBA_4444: {16, 0xF000, 0x0F00, 0x00F0, 0x000F},
+	PVR_OGL_RGBA_5551: {16, 0xF800, 0x07C0, 0x003E, 0x0001},
+	PVR_OGL_RGBA_8888: {32, 0x000000FF, 0x0000FF00, 0x00FF0000, 0xFF000000},
+	PVR_OGL_RGB_565:   {16, 0xF800, 0x07E0, 0x001F, 0},
+	PVR_OGL_RGB_555:   {16, 0x7C00, 0x03E0, 0x001F, 0},
+	PVR_OGL_RGB_888:   {24, 0x0000FF, 0x00FF00, 0xFF0000, 0},
+	PVR_OGL_I_8:       {8, 0xFF, 0xFF, 0xFF, 0},
+	PVR_OGL_AI_88:     {16, 0x00FF, 0x00FF, 0x00FF, 0xFF00},
+	PVR_OGL_BGRA_8888: {32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0xFF000000},
+	PVR_OGL_A_8:       {8, 0, 0, 0, 0xFF},
+}
+
+// bitMasks returns the channel masks of the header's pixel type. Masks stored in the header take precedence
+// over the pixel type's defaults. ok is false for pixel types that aren't uncompressed
+func (h PVRTC2Header) bitMasks() (masks pvrBitMasks, ok bool) {
+	masks, ok = pvrDefaultBitMasks[h.PixelType()]
+	if !ok {
+		return masks, false
+	}
+
+	if h.BitMaskR|h.BitMaskG|h.BitMaskB|h.BitMaskA != 0 && h.BitCount > 0 {
+		masks = pvrBitMasks{int(h.BitCount), h.BitMaskR, h.BitMaskG, h.BitMaskB, h.BitMaskA}
+	}
+
+	return masks, true
+}
+
+// checkBitCount makes sure pixels of bitCount bits can be read as whole bytes
+func checkBitCount(bitCount int) error {
+	if bitCount%8 != 0 || bitCount < 8 || bitCount > 32 {
+		return fmt.Errorf("a bit count of %d is unsupported", bitCount)
+	}
+
+	return nil
+}
+
+// checkBitMasks makes sure the bit count of the header's uncompressed pixel type is supported.
+// Other pixel types pass, as their bit count isn't used to read pixels
+func (h PVRTC2Header) checkBitMasks() error {
+	if masks, ok := h.bitMasks(); ok {
+		return checkBitCount(masks.bitCount)
+	}
+
+	return nil
+}
+
+// extractChannel returns the bits of pixel selected by mask, scaled to 8 bits
+func extractChannel(pixel uint32, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+
+	width := bits.OnesCount32(mask)
+	value := int((pixel & mask) >> bits.TrailingZeros32(mask))
+	if width > 8 {
+		return uint8(value >> (width - 8))
+	}
+
+	return uint8(expandBits(value, width))
+}
+
+// packChannel scales an 8-bit value to the bits selected by mask
+func packChannel(value uint8, mask uint32) uint32 {
+	if mask == 0 {
+		return 0
+	}
+
+	width := bits.OnesCount32(mask)
+	if width > 8 {
+		return uint32(value) << (width - 8) << bits.TrailingZeros32(mask) & mask
+	}
+
+	maxValue := uint32(1)<<width - 1
+	return (uint32(value)*maxValue + 127) / 255 << bits.TrailingZeros32(mask) & mask
+}
+
+// decodeUncompressed decodes an uncompressed surface of the given size
+func decodeUncompressed(data []byte, width int, height int, masks pvrBitMasks) (*image.NRGBA, error) {
+	if err := checkBitCount(masks.bitCount); err != nil {
+		return nil, err
+	}
+
+	bytesPerPixel := masks.bitCount / 8
+	if len(data) < width*height*bytesPerPixel {
+		return nil, errors.New("PVR data is too short for the texture's dimensions")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		pixel := uint32(0)
+		for b := 0; b < bytesPerPixel; b++ {
+			pixel |= uint32(data[i*bytesPerPixel+b]) << (8 * b)
+		}
+
+		alpha := uint8(0xFF)
+		if masks.a != 0 {
+			alpha = extractChannel(pixel, masks.a)
+		}
+
+		img.Pix[i*4] = extractChannel(pixel, masks.r)
+		img.Pix[i*4+1] = extractChannel(pixel, masks.g)
+		img.Pix[i*4+2] = extractChannel(pixel, masks.b)
+		img.Pix[i*4+3] = alpha
+	}
+
+	return img, nil
+}
+
+// encodeUncompressed stores img's pixels using the given masks
+func encodeUncompressed(img *image.NRGBA, masks pvrBitMasks) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	bytesPerPixel := masks.bitCount / 8
+
+	data := make([]byte, width*height*bytesPerPixel)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			pixel := packChannel(img.Pix[i], masks.r) | packChannel(img.Pix[i+1], masks.g) |
+				packChannel(img.Pix[i+2], masks.b) | packChannel(img.Pix[i+3], masks.a)
+
+			offset := (y*width + x) * bytesPerPixel
+			for b := 0; b < bytesPerPixel; b++ {
+				data[offset+b] = byte(pixel >> (8 * b))
+			}
+		}
+	}
+
+	return data
+}
+
+// reorderTwiddled converts an uncompressed surface between row-major and twiddled order.
+// Twiddled surfaces store their pixels in Morton order, see mortonIndex
+func reorderTwiddled(data []byte, width int, height int, bytesPerPixel int, twiddle bool) ([]byte, error) {
+	if !isPowerOfTwo(width) || !isPowerOfTwo(height) {
+		return nil, fmt.Errorf("twiddled textures need power-of-two sides, but the surface is %dx%d", width, height)
+	} else if len(data) < width*height*bytesPerPixel {
+		return nil, errors.New("PVR data is too short for the texture's dimensions")
+	}
+
+	reordered := make([]byte, width*height*bytesPerPixel)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			linear, twiddled := (y*width+x)*bytesPerPixel, mortonIndex(x, y, width, height)*bytesPerPixel
+			if twiddle {
+				copy(reordered[twiddled:twiddled+bytesPerPixel], data[linear:])
+			} else {
+				copy(reordered[linear:linear+bytesPerPixel], data[twiddled:])
+			}
+		}
+	}
+
+	return reordered, nil
+}
+
+// untwiddle returns a copy of a legacy PVR texture with every uncompressed surface and mip level stored in row-major order.
+// Other containers have no notion of twiddling, so textures need to be untwiddled before they're converted.
+// PVRTC textures are always twiddled and are returned as they are
+func untwiddle(h PVRTC2Header, data []byte) (PVRTC2Header, []byte, error) {
+	masks, ok := h.bitMasks()
+	if !ok || h.PixelFormatFlags&PVR_FLAG_TWIDDLE == 0 {
+		return h, data, nil
+	}
+
+	levels, _ := h.Levels()
+	untwiddled := append([]byte(nil), data...)
+	for _, level := range levels {
+		if level.Offset+level.Size > len(data) {
+			return h, nil, errors.New("PVR data is too short for the texture's dimensions")
+		}
+
+		surface, err := reorderTwiddled(data[level.Offset:level.Offset+level.Size], level.Width, level.Height, masks.bitCount/8, false)
+		if err != nil {
+			return h, nil, err
+		}
+		copy(untwiddled[level.Offset:], surface)
+	}
+
+	h.PixelFormatFlags &^= PVR_FLAG_TWIDDLE
+	return h, untwiddled, nil
+}
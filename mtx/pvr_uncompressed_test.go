@@ -0,0 +1,249 @@
+package mtx
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+)
+
+// twiddleTests lists the twiddled index of every pixel in row-major order. Bits of y come before bits of x,
+// and non-square sizes are stored as squares of the smaller side one after the other
+var twiddleTests = []struct {
+	width, height int
+	indices       []int
+}{
+	{1, 1, []int{0}},
+	{2, 1, []int{0, 1}},
+	{1, 2, []int{0, 1}},
+	{2, 2, []int{
+		0, 2,
+		1, 3,
+	}},
+	{4, 2, []int{
+		0, 2, 4, 6,
+		1, 3, 5, 7,
+	}},
+	{2, 4, []int{
+		0, 2,
+		1, 3,
+		4, 6,
+		5, 7,
+	}},
+	{8, 2, []int{
+		0, 2, 4, 6, 8, 10, 12, 14,
+		1, 3, 5, 7, 9, 11, 13, 15,
+	}},
+	{4, 4, []int{
+		0, 2, 8, 10,
+		1, 3, 9, 11,
+		4, 6, 12, 14,
+		5, 7, 13, 15,
+	}},
+	{8, 4, []int{
+		0, 2, 8, 10, 16, 18, 24, 26,
+		1, 3, 9, 11, 17, 19, 25, 27,
+		4, 6, 12, 14, 20, 22, 28, 30,
+		5, 7, 13, 15, 21, 23, 29, 31,
+	}},
+	{2, 8, []int{
+		0, 2,
+		1, 3,
+		4, 6,
+		5, 7,
+		8, 10,
+		9, 11,
+		12, 14,
+		13, 15,
+	}},
+}
+
+func TestReorderTwiddled(t *testing.T) {
+	for _, test := range twiddleTests {
+		t.Run(fmt.Sprintf("%dx%d", test.width, test.height), func(t *testing.T) {
+			linear, twiddled := make([]byte, len(test.indices)), make([]byte, len(test.indices))
+			for i, index := range test.indices {
+				linear[i] = byte(i)
+				twiddled[index] = byte(i)
+			}
+
+			got, err := reorderTwiddled(linear, test.width, test.height, 1, true)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, twiddled) {
+				t.Errorf("twiddling got %v, want %v", got, twiddled)
+			}
+
+			got, err = reorderTwiddled(twiddled, test.width, test.height, 1, false)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, linear) {
+				t.Errorf("untwiddling got %v, want %v", got, linear)
+			}
+		})
+	}
+}
+
+func TestReorderTwiddledRejectsNonPowerOfTwoSizes(t *testing.T) {
+	if _, err := reorderTwiddled(make([]byte, 6), 3, 2, 1, true); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestUntwiddle(t *testing.T) {
+	for _, test := range twiddleTests {
+		t.Run(fmt.Sprintf("%dx%d", test.width, test.height), func(t *testing.T) {
+			header, err := newLegacyPVRHeader(PVR_OGL_RGBA_8888, test.width, test.height, 1, 1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header.PixelFormatFlags |= PVR_FLAG_TWIDDLE
+
+			// every pixel holds its row-major index in its red channel and its twiddled index in its green one
+			linear, twiddled := make([]byte, len(test.indices)*4), make([]byte, len(test.indices)*4)
+			for i, index := range test.indices {
+				pixel := []byte{byte(i), byte(index), 0, 0xFF}
+				copy(linear[i*4:], pixel)
+				copy(twiddled[index*4:], pixel)
+			}
+
+			header, got, err := untwiddle(header, twiddled)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, linear) {
+				t.Errorf("got %v, want %v", got, linear)
+			} else if header.PixelFormatFlags&PVR_FLAG_TWIDDLE != 0 {
+				t.Error("the twiddle flag is still set")
+			}
+		})
+	}
+}
+
+func TestUntwiddleMipLevels(t *testing.T) {
+	// 8x4, 4x2, 2x1 and 1x1 levels, stored one after the other
+	header, err := newLegacyPVRHeader(PVR_OGL_RGBA_8888, 8, 4, 4, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.PixelFormatFlags |= PVR_FLAG_TWIDDLE
+
+	var linear, twiddled []byte
+	for level, size := range [][2]int{{8, 4}, {4, 2}, {2, 1}, {1, 1}} {
+		var indices []int
+		for _, test := range twiddleTests {
+			if test.width == size[0] && test.height == size[1] {
+				indices = test.indices
+			}
+		}
+
+		levelLinear, levelTwiddled := make([]byte, len(indices)*4), make([]byte, len(indices)*4)
+		for i, index := range indices {
+			pixel := []byte{byte(i), byte(index), byte(level), 0xFF}
+			copy(levelLinear[i*4:], pixel)
+			copy(levelTwiddled[index*4:], pixel)
+		}
+		linear, twiddled = append(linear, levelLinear...), append(twiddled, levelTwiddled...)
+	}
+
+	_, got, err := untwiddle(header, twiddled)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, linear) {
+		t.Errorf("got %v, want %v", got, linear)
+	}
+}
+
+// uncompressedTestImage returns a 4x4 image whose first pixel is opaque red and whose other pixels vary in color and transparency
+func uncompressedTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		img.SetNRGBA(i%4, i/4, color.NRGBA{uint8(255 - i*17), uint8(i * 17), uint8(i * 40), uint8(255 - i*13)})
+	}
+
+	return img
+}
+
+func TestUncompressedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   PVRFormat
+		masks    pvrBitMasks // custom masks stored in the header, replacing those of PVR_OGL_RGBA_8888
+		wantMask pvrBitMasks
+		wantRed  uint32 // the first pixel as stored
+	}{
+		{"rgba8888", PVRFormatRGBA8888, pvrBitMasks{}, pvrBitMasks{32, 0x000000FF, 0x0000FF00, 0x00FF0000, 0xFF000000}, 0xFF0000FF},
+		{"rgba4444", PVRFormatRGBA4444, pvrBitMasks{}, pvrBitMasks{16, 0xF000, 0x0F00, 0x00F0, 0x000F}, 0xF00F},
+		{"rgb565", PVRFormatRGB565, pvrBitMasks{}, pvrBitMasks{16, 0xF800, 0x07E0, 0x001F, 0}, 0xF800},
+		{"rgba5551", PVRFormatRGBA5551, pvrBitMasks{}, pvrBitMasks{16, 0xF800, 0x07C0, 0x003E, 0x0001}, 0xF801},
+		{"argb1555", PVRFormatARGB1555, pvrBitMasks{}, pvrBitMasks{16, 0x7C00, 0x03E0, 0x001F, 0x8000}, 0xFC00},
+		{"custom argb8888", "", pvrBitMasks{32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0xFF000000}, pvrBitMasks{32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0xFF000000}, 0xFFFF0000},
+		{"custom rgb888", "", pvrBitMasks{24, 0xFF0000, 0x00FF00, 0x0000FF, 0}, pvrBitMasks{24, 0xFF0000, 0x00FF00, 0x0000FF, 0}, 0xFF0000},
+	}
+
+	want := uncompressedTestImage()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var header PVRTC2Header
+			var data []byte
+			if test.format != "" {
+				var err error
+				if header, data, err = EncodePVRImage(want, &BakeOptions{PVRFormat: test.format}); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var err error
+				if header, err = newLegacyPVRHeader(PVR_OGL_RGBA_8888, 4, 4, 1, 1, 1); err != nil {
+					t.Fatal(err)
+				}
+				header.BitCount = uint32(test.masks.bitCount)
+				header.BitMaskR, header.BitMaskG, header.BitMaskB, header.BitMaskA = test.masks.r, test.masks.g, test.masks.b, test.masks.a
+
+				masks, _ := header.bitMasks()
+				data = encodeUncompressed(want, masks)
+				header.CompressedDataSize = uint32(len(data))
+			}
+
+			if masks, _ := header.bitMasks(); masks != test.wantMask {
+				t.Errorf("got masks %+v, want %+v", masks, test.wantMask)
+			} else if header.BitCount != uint32(test.wantMask.bitCount) {
+				t.Errorf("got a bit count of %d, want %d", header.BitCount, test.wantMask.bitCount)
+			}
+
+			bytesPerPixel := test.wantMask.bitCount / 8
+			if len(data) != 16*bytesPerPixel {
+				t.Fatalf("got %d bytes, want %d", len(data), 16*bytesPerPixel)
+			}
+			red := uint32(0)
+			for b := 0; b < bytesPerPixel; b++ {
+				red |= uint32(data[b]) << (8 * b)
+			}
+			if red != test.wantRed {
+				t.Errorf("got the first pixel stored as 0x%X, want 0x%X", red, test.wantRed)
+			}
+
+			img, err := DecodePVRImage(header, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// every channel may be off by up to half a step of its stored precision. Textures without alpha are opaque
+			masks := []uint32{test.wantMask.r, test.wantMask.g, test.wantMask.b, test.wantMask.a}
+			for i := range img.Pix {
+				got, original, mask := int(img.Pix[i]), int(want.Pix[i]), masks[i%4]
+				if mask == 0 && i%4 == 3 {
+					original = 0xFF
+				}
+
+				tolerance := 0
+				if width := bits.OnesCount32(mask); width > 0 && width < 8 {
+					tolerance = ceilDiv(0xFF, 2*(1<<width-1))
+				}
+				if got-original > tolerance || original-got > tolerance {
+					t.Errorf("pixel %d, channel %d: got %d, want %d±%d", i/4, i%4, got, original, tolerance)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+)
+
+/*
+PVRTC stores textures in 64-bit blocks of 4x4 (4bpp) or 8x4 (2bpp) pixels.
+Each block holds two low-resolution colors A and B and a modulation value for every pixel.
+When decoding, the A and B colors of the four blocks surrounding a pixel are bilinearly interpolated
+and the pixel's modulation value then blends between the interpolated A and B colors.
+
+PVRTC-II (the format used by MTXv2 files) keeps the layout, but adds a hard transition flag
+that disables interpolation for the region between the centers of a block and its neighbors
+to the right and bottom, and a local palette mode for such regions.
+Unlike PVRTC1, its blocks aren't stored in twiddled order
+*/
+
+// pvrtcColor is a color with 8 bits per channel
+type pvrtcColor struct {
+	r, g, b, a int
+}
+
+// pvrtcBlock is a single PVRTC block
+type pvrtcBlock struct {
+	modulation uint32
+	color      uint32
+}
+
+// hard returns the PVRTC-II hard transition flag
+func (b pvrtcBlock) hard() bool {
+	return b.color&0x8000 != 0
+}
+
+// modulationFlag returns the flag selecting the block's modulation mode
+func (b pvrtcBlock) modulationFlag() bool {
+	return b.color&1 != 0
+}
+
+// colorA returns the block's A color. In PVRTC1 it has its own opacity flag, PVRTC-II shares the one of color B
+func (b pvrtcBlock) colorA(pvrtcII bool) pvrtcColor {
+	c := int(b.color & 0xFFFF)
+	opaque := c&0x8000 != 0
+	if pvrtcII {
+		opaque = b.color&0x80000000 != 0
+	}
+
+	if opaque {
+		return pvrtcColor{expandBits(c>>10, 5), expandBits(c>>5, 5), expandBits(c>>1, 4), 0xFF}
+	}
+
+	return pvrtcColor{expandBits(c>>8, 4), expandBits(c>>4, 4), expandBits(c>>1, 3), translucentAlpha(c)}
+}
+
+// colorB returns the block's B color
+func (b pvrtcBlock) colorB() pvrtcColor {
+	c := int(b.color >> 16)
+	if c&0x8000 != 0 {
+		return pvrtcColor{expandBits(c>>10, 5), expandBits(c>>5, 5), expandBits(c, 5), 0xFF}
+	}
+
+	return pvrtcColor{expandBits(c>>8, 4), expandBits(c>>4, 4), expandBits(c, 4), translucentAlpha(c)}
+}
+
+// translucentAlpha returns the 3-bit alpha of a translucent color. It's treated as a 4-bit value
+// whose lowest bit is zero, so translucent colors are never fully opaque
+func translucentAlpha(c int) int {
+	return (c >> 12 & 7) * 2 * 0x11
+}
+
+// expandBits scales the lowest bits of value to 8 bits by repeating them
+func expandBits(value int, bits int) int {
+	value &= 1<<bits - 1
+
+	expanded := 0
+	for shift := 8 - bits; shift > -bits; shift -= bits {
+		if shift >= 0 {
+			expanded |= value << shift
+		} else {
+			expanded |= value >> -shift
+		}
+	}
+
+	return expanded
+}
+
+// modulation weights of color B in eighths, indexed by 2-bit modulation values
+var (
+	pvrtcStandardWeights     = [4]int{0, 3, 5, 8}
+	pvrtcPunchThroughWeights = [4]int{0, 4, 4, 8}
+)
+
+// pvrtcLayout describes how the blocks of a PVRTC surface are arranged
+type pvrtcLayout struct {
+	pvrtcII bool
+	twoBPP  bool
+
+	blockWidth int // 4 for 4bpp, 8 for 2bpp. Blocks are always 4 pixels high
+	blocksX    int
+	blocksY    int
+}
+
+func newPVRTCLayout(width int, height int, twoBPP bool, pvrtcII bool) (pvrtcLayout, error) {
+	l := pvrtcLayout{
+		pvrtcII:    pvrtcII,
+		twoBPP:     twoBPP,
+		blockWidth: 4,
+	}
+	if twoBPP {
+		l.blockWidth = 8
+	}
+
+	if pvrtcII {
+		l.blocksX, l.blocksY = ceilDiv(width, l.blockWidth), ceilDiv(height, 4)
+	} else {
+		// PVRTC1 textures are padded to at least two blocks in each direction
+		l.blocksX, l.blocksY = ceilDiv(atLeast(width, l.blockWidth*2), l.blockWidth), ceilDiv(atLeast(height, 8), 4)
+		if !isPowerOfTwo(l.blocksX) || !isPowerOfTwo(l.blocksY) {
+			return l, errors.New("PVRTC1 textures need to have power-of-two dimensions")
+		}
+	}
+
+	return l, nil
+}
+
+// dataSize returns the number of bytes taken up by the surface's blocks
+func (l pvrtcLayout) dataSize() int {
+	return l.blocksX * l.blocksY * 8
+}
+
+// blockIndex returns the position of the block at the given block coordinates in the surface's data,
+// wrapping around the texture's edges
+func (l pvrtcLayout) blockIndex(x int, y int) int {
+	x = (x%l.blocksX + l.blocksX) % l.blocksX
+	y = (y%l.blocksY + l.blocksY) % l.blocksY
+
+	if !l.pvrtcII {
+		return mortonIndex(x, y, l.blocksX, l.blocksY)
+	}
+
+	return y*l.blocksX + x
+}
+
+// surroundingBlocks returns the coordinates of block P, whose center is the closest one above and to the left
+// of the pixel at x, y, and the pixel's offset from that center. Blocks Q, R and S follow to the right and bottom
+func (l pvrtcLayout) surroundingBlocks(x int, y int) (px int, py int, fx int, fy int) {
+	halfWidth := l.blockWidth / 2
+	px, py = floorDiv(x-halfWidth, l.blockWidth), floorDiv(y-2, 4)
+	return px, py, x - halfWidth - px*l.blockWidth, y - 2 - py*4
+}
+
+// pvrtcDecoder decodes a single PVRTC surface
+type pvrtcDecoder struct {
+	pvrtcLayout
+	data []byte
+}
+
+func newPVRTCDecoder(data []byte, width int, height int, twoBPP bool, pvrtcII bool) (*pvrtcDecoder, error) {
+	layout, err := newPVRTCLayout(width, height, twoBPP, pvrtcII)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < layout.dataSize() {
+		return nil, errors.New("PVRTC data is too short for the texture's dimensions")
+	}
+
+	return &pvrtcDecoder{pvrtcLayout: layout, data: data}, nil
+}
+
+// block returns the block at the given block coordinates, wrapping around the texture's edges
+func (d *pvrtcDecoder) block(x int, y int) pvrtcBlock {
+	index := d.blockIndex(x, y)
+	return pvrtcBlock{
+		modulation: binary.LittleEndian.Uint32(d.data[index*8:]),
+		color:      binary.LittleEndian.Uint32(d.data[index*8+4:]),
+	}
+}
+
+// modulationIndex returns the 2-bit modulation value of the pixel at x, y.
+// For 2bpp blocks, only every other pixel stores a value and the others are interpolated by modulationWeight
+func (d *pvrtcDecoder) modulationIndex(x int, y int) int {
+	bx, by := floorDiv(x, d.blockWidth), floorDiv(y, 4)
+	block := d.block(bx, by)
+	px, py := x-bx*d.blockWidth, y-by*4
+
+	if !d.twoBPP {
+		return int(block.modulation>>(2*(py*4+px))) & 3
+	}
+
+	if !block.modulationFlag() {
+		// one bit per pixel, selecting either color A or B
+		return (int(block.modulation>>(py*8+px)) & 1) * 3
+	}
+
+	// two bits for every other pixel in a checkerboard pattern. The lowest bit of the first
+	// and the eleventh value are used as mode flags and are replaced by their neighboring bits
+	modulation := block.modulation
+	if modulation&1 != 0 {
+		modulation = modulation&^(1<<20) | (modulation>>1)&(1<<20)
+	}
+	modulation = modulation&^1 | (modulation>>1)&1
+
+	return int(modulation>>(2*(py*4+px/2))) & 3
+}
+
+// modulationWeight returns the weight of color B for the pixel at x, y in eighths, and whether the pixel is punched through
+func (d *pvrtcDecoder) modulationWeight(x int, y int) (int, bool) {
+	bx, by := floorDiv(x, d.blockWidth), floorDiv(y, 4)
+	block := d.block(bx, by)
+	index := d.modulationIndex(x, y)
+
+	if !d.twoBPP {
+		if block.modulationFlag() {
+			return pvrtcPunchThroughWeights[index], index == 2
+		}
+		return pvrtcStandardWeights[index], false
+	}
+
+	if !block.modulationFlag() || (x^y)&1 == 0 {
+		return pvrtcStandardWeights[index], false
+	}
+
+	// interpolate missing 2bpp values from their neighbors, either in all directions,
+	// only horizontally or only vertically as selected by the mode flags
+	horizontal := pvrtcStandardWeights[d.modulationIndex(x-1, y)] + pvrtcStandardWeights[d.modulationIndex(x+1, y)]
+	vertical := pvrtcStandardWeights[d.modulationIndex(x, y-1)] + pvrtcStandardWeights[d.modulationIndex(x, y+1)]
+	switch {
+	case block.modulation&1 == 0:
+		return (horizontal + vertical + 2) / 4, false
+	case block.modulation&(1<<20) == 0:
+		return (horizontal + 1) / 2, false
+	default:
+		return (vertical + 1) / 2, false
+	}
+}
+
+// localPaletteColor returns the color of a pixel in PVRTC-II's local palette mode.
+// Each pixel chooses from the A and B colors of two of the four surrounding blocks, depending on its quadrant
+func (d *pvrtcDecoder) localPaletteColor(blocks [4]pvrtcBlock, quadrant int, index int) pvrtcColor {
+	palettes := [4][2]int{
+		{0, 2}, // top left: P and R
+		{1, 3}, // top right: Q and S
+		{0, 2}, // bottom left: P and R
+		{3, 2}, // bottom right: S and R
+	}
+
+	block := blocks[palettes[quadrant][index/2]]
+	if index%2 == 0 {
+		return block.colorA(true)
+	}
+
+	return block.colorB()
+}
+
+// pixel decodes the pixel at x, y
+func (d *pvrtcDecoder) pixel(x int, y int) pvrtcColor {
+	// P, Q, R and S are the blocks whose centers surround the pixel
+	px, py, fx, fy := d.surroundingBlocks(x, y)
+	blocks := [4]pvrtcBlock{d.block(px, py), d.block(px+1, py), d.block(px, py+1), d.block(px+1, py+1)}
+
+	own := d.block(floorDiv(x, d.blockWidth), floorDiv(y, 4))
+	weightX, weightY := fx, fy
+	colorsA, colorsB := [4]pvrtcColor{}, [4]pvrtcColor{}
+	if d.pvrtcII && blocks[0].hard() {
+		if !d.twoBPP && own.modulationFlag() {
+			quadrant := fx*2/d.blockWidth + fy/2*2
+			return d.localPaletteColor(blocks, quadrant, d.modulationIndex(x, y))
+		}
+
+		// no interpolation, every pixel uses the colors of its own block
+		weightX, weightY = 0, 0
+		blocks[0] = own
+	}
+
+	for i, block := range blocks {
+		colorsA[i], colorsB[i] = block.colorA(d.pvrtcII), block.colorB()
+	}
+
+	weight, punchThrough := d.modulationWeight(x, y)
+	blend := func(channel func(pvrtcColor) int) int {
+		a := bilinear(channel(colorsA[0]), channel(colorsA[1]), channel(colorsA[2]), channel(colorsA[3]), weightX, weightY, d.blockWidth, 4)
+		b := bilinear(channel(colorsB[0]), channel(colorsB[1]), channel(colorsB[2]), channel(colorsB[3]), weightX, weightY, d.blockWidth, 4)
+
+		// truncating matches hardware decoders, which matters for dark linear-light colors
+		scale := d.blockWidth * 4 * 8
+		return (a*(8-weight) + b*weight) / scale
+	}
+
+	c := pvrtcColor{
+		r: blend(func(c pvrtcColor) int { return c.r }),
+		g: blend(func(c pvrtcColor) int { return c.g }),
+		b: blend(func(c pvrtcColor) int { return c.b }),
+		a: blend(func(c pvrtcColor) int { return c.a }),
+	}
+	if punchThrough {
+		c.a = 0
+	}
+
+	return c
+}
+
+// bilinear interpolates between p, q, r and s at x/width, y/height, without dividing the result
+func bilinear(p int, q int, r int, s int, x int, y int, width int, height int) int {
+	return p*(width-x)*(height-y) + q*x*(height-y) + r*(width-x)*y + s*x*y
+}
+
+// decodePVRTC decodes a PVRTC1 or PVRTC-II surface of the given size
+func decodePVRTC(data []byte, width int, height int, twoBPP bool, pvrtcII bool) (*image.NRGBA, error) {
+	d, err := newPVRTCDecoder(data, width, height, twoBPP, pvrtcII)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := d.pixel(x, y)
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(c.r), uint8(c.g), uint8(c.b), uint8(c.a)
+		}
+	}
+
+	return img, nil
+}
+
+// mortonIndex returns the index of x, y in twiddled order. Non-square sizes are twiddled
+// in squares of the smaller dimension, which are stored one after the other
+func mortonIndex(x int, y int, width int, height int) int {
+	minDimension, rest := width, y
+	if height < width {
+		minDimension, rest = height, x
+	}
+
+	index, shift := 0, 0
+	for bit := 1; bit < minDimension; bit <<= 1 {
+		if y&bit != 0 {
+			index |= 1 << (2 * shift)
+		}
+		if x&bit != 0 {
+			index |= 2 << (2 * shift)
+		}
+		shift++
+	}
+
+	return index | rest>>shift<<(2*shift)
+}
+
+// isPowerOfTwo checks whether n is a positive power of two
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// floorDiv divides a by b, rounding towards negative infinity
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+
+	return a / b
+}
@@ -0,0 +1,343 @@
+package mtx
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+)
+
+/*
+The encoder creates PVRTC-II textures (pixel types 0x1C and 0x1D), like the games' own MTXv2 files hold, and PVRTC1 textures
+(pixel types 0x18 and 0x19). Whether the games load PVRTC1 textures hasn't been verified. PVRTC-II blocks are written without
+the hard transition flag, so they're interpolated just like PVRTC1 blocks, but their A and B colors share a single opacity flag.
+It starts out by using the darkest and brightest colors of each block as its A and B colors and picks
+the best modulation value for every pixel. Each block's colors are then refined with a least squares fit
+against the pixels they influence, and new modulation values are picked. The fast mode does this once,
+the iterative mode repeats it a few times
+*/
+
+const (
+	pvrtcIterations = 4 // number of refinement passes in iterative mode. The fast mode does a single pass
+
+	// translucent colors can't be more opaque than 14/15, so anything closer to opaque is stored as opaque
+	pvrtcOpaqueThreshold = 0xEE + (0xFF-0xEE)/2
+)
+
+// pvrtcVector is a color with floating-point channels ranging from 0 to 255
+type pvrtcVector [4]float64
+
+// vector converts c to a pvrtcVector
+func (c pvrtcColor) vector() pvrtcVector {
+	return pvrtcVector{float64(c.r), float64(c.g), float64(c.b), float64(c.a)}
+}
+
+// pvrtcEncoder compresses a single PVRTC1 or PVRTC-II surface
+type pvrtcEncoder struct {
+	pvrtcLayout
+	width  int
+	height int
+	pixels []pvrtcColor
+
+	blocks  []pvrtcBlock // blocks in row-major order. PVRTC1 blocks are twiddled when the data is written
+	indices []int        // modulation value of every pixel
+}
+
+func newPVRTCEncoder(img *image.NRGBA, twoBPP bool, pvrtcII bool) (*pvrtcEncoder, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	layout, err := newPVRTCLayout(width, height, twoBPP, pvrtcII)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &pvrtcEncoder{
+		pvrtcLayout: layout,
+		width:       width,
+		height:      height,
+		pixels:      make([]pvrtcColor, width*height),
+		blocks:      make([]pvrtcBlock, layout.blocksX*layout.blocksY),
+		indices:     make([]int, width*height),
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			e.pixels[y*width+x] = pvrtcColor{int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2]), int(img.Pix[i+3])}
+		}
+	}
+
+	return e, nil
+}
+
+// pixel returns the source pixel at x, y, wrapping around the image's edges
+func (e *pvrtcEncoder) pixel(x int, y int) pvrtcColor {
+	x = (x%e.width + e.width) % e.width
+	y = (y%e.height + e.height) % e.height
+	return e.pixels[y*e.width+x]
+}
+
+// block returns the block at the given block coordinates, wrapping around the texture's edges
+func (e *pvrtcEncoder) block(x int, y int) *pvrtcBlock {
+	x = (x%e.blocksX + e.blocksX) % e.blocksX
+	y = (y%e.blocksY + e.blocksY) % e.blocksY
+	return &e.blocks[y*e.blocksX+x]
+}
+
+// weights returns the modulation weights of color B in eighths, indexed by modulation values
+func (e *pvrtcEncoder) weights() []int {
+	if e.twoBPP {
+		// 2bpp blocks are encoded with one bit per pixel, which decodes to the values 0 and 3
+		return []int{0, 0, 0, 8}
+	}
+
+	return pvrtcStandardWeights[:]
+}
+
+// endpoints returns the A and B colors interpolated at x, y, scaled by blockWidth * 4
+func (e *pvrtcEncoder) endpoints(x int, y int) (pvrtcColor, pvrtcColor) {
+	px, py, fx, fy := e.surroundingBlocks(x, y)
+	blocks := [4]*pvrtcBlock{e.block(px, py), e.block(px+1, py), e.block(px, py+1), e.block(px+1, py+1)}
+
+	var colorsA, colorsB [4]pvrtcColor
+	for i, block := range blocks {
+		colorsA[i], colorsB[i] = block.colorA(e.pvrtcII), block.colorB()
+	}
+
+	blend := func(colors [4]pvrtcColor, channel func(pvrtcColor) int) int {
+		return bilinear(channel(colors[0]), channel(colors[1]), channel(colors[2]), channel(colors[3]), fx, fy, e.blockWidth, 4)
+	}
+	channels := [4]func(pvrtcColor) int{
+		func(c pvrtcColor) int { return c.r },
+		func(c pvrtcColor) int { return c.g },
+		func(c pvrtcColor) int { return c.b },
+		func(c pvrtcColor) int { return c.a },
+	}
+
+	var a, b [4]int
+	for i, channel := range channels {
+		a[i], b[i] = blend(colorsA, channel), blend(colorsB, channel)
+	}
+
+	return pvrtcColor{a[0], a[1], a[2], a[3]}, pvrtcColor{b[0], b[1], b[2], b[3]}
+}
+
+// initBlocks uses the darkest and brightest colors of each block's pixels as its A and B colors
+func (e *pvrtcEncoder) initBlocks() {
+	for by := 0; by < e.blocksY; by++ {
+		for bx := 0; bx < e.blocksX; bx++ {
+			low := pvrtcVector{255, 255, 255, 255}
+			high := pvrtcVector{}
+			for y := by * 4; y < by*4+4; y++ {
+				for x := bx * e.blockWidth; x < (bx+1)*e.blockWidth; x++ {
+					c := e.pixel(x, y).vector()
+					for i, value := range c {
+						low[i] = math.Min(low[i], value)
+						high[i] = math.Max(high[i], value)
+					}
+				}
+			}
+
+			e.block(bx, by).setColors(low, high, e.pvrtcII)
+		}
+	}
+}
+
+// chooseModulation picks the modulation value that best matches the source for every pixel
+func (e *pvrtcEncoder) chooseModulation() {
+	weights := e.weights()
+	scale := e.blockWidth * 4 * 8
+
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			a, b := e.endpoints(x, y)
+			source := e.pixels[y*e.width+x]
+
+			best, bestError := 0, math.MaxInt
+			for index, weight := range weights {
+				if index != 0 && weight == weights[index-1] {
+					continue
+				}
+
+				blend := func(a int, b int) int {
+					return (a*(8-weight) + b*weight) / scale
+				}
+				c := pvrtcColor{blend(a.r, b.r), blend(a.g, b.g), blend(a.b, b.b), blend(a.a, b.a)}
+				if err := colorError(c, source); err < bestError {
+					best, bestError = index, err
+				}
+			}
+
+			e.indices[y*e.width+x] = best
+		}
+	}
+}
+
+// refineBlocks fits the colors of every block to the pixels they influence, using the current modulation values.
+// Every block is updated in turn, so each fit already sees the refined colors of the blocks before it
+func (e *pvrtcEncoder) refineBlocks() {
+	weights := e.weights()
+	area := float64(e.blockWidth * 4)
+
+	for by := 0; by < e.blocksY; by++ {
+		for bx := 0; bx < e.blocksX; bx++ {
+			block := e.block(bx, by)
+			ownA, ownB := block.colorA(e.pvrtcII).vector(), block.colorB().vector()
+
+			// solve the normal equations for the A and B colors minimizing the squared error
+			// of the pixels between the centers of the block's neighbors
+			var sumAA, sumAB, sumBB float64
+			var sumAY, sumBY pvrtcVector
+			centerX, centerY := bx*e.blockWidth+e.blockWidth/2, by*4+2
+			for y := centerY - 3; y < centerY+4; y++ {
+				for x := centerX - e.blockWidth + 1; x < centerX+e.blockWidth; x++ {
+					dx, dy := math.Abs(float64(x-centerX)), math.Abs(float64(y-centerY))
+					influence := (float64(e.blockWidth) - dx) * (4 - dy) / area
+
+					wrappedX, wrappedY := (x%e.width+e.width)%e.width, (y%e.height+e.height)%e.height
+					modulation := float64(weights[e.indices[wrappedY*e.width+wrappedX]]) / 8
+					a, b := influence*(1-modulation), influence*modulation
+
+					endpointA, endpointB := e.endpoints(x, y)
+					interpolatedA, interpolatedB := endpointA.vector(), endpointB.vector()
+					source := e.pixel(x, y).vector()
+					for i := range source {
+						// remove the block's own contribution, leaving the one of its neighbors
+						others := (interpolatedA[i]/area-influence*ownA[i])*(1-modulation) + (interpolatedB[i]/area-influence*ownB[i])*modulation
+						sumAY[i] += a * (source[i] - others)
+						sumBY[i] += b * (source[i] - others)
+					}
+
+					sumAA += a * a
+					sumAB += a * b
+					sumBB += b * b
+				}
+			}
+
+			newA, newB := ownA, ownB
+			det := sumAA*sumBB - sumAB*sumAB
+			for i := range newA {
+				switch {
+				case det > 1e-9:
+					newA[i] = (sumBB*sumAY[i] - sumAB*sumBY[i]) / det
+					newB[i] = (sumAA*sumBY[i] - sumAB*sumAY[i]) / det
+				case sumBB < 1e-9 && sumAA > 1e-9:
+					// no pixel uses color B, so only A can be fitted
+					newA[i] = sumAY[i] / sumAA
+				case sumAA < 1e-9 && sumBB > 1e-9:
+					newB[i] = sumBY[i] / sumBB
+				}
+			}
+
+			block.setColors(newA, newB, e.pvrtcII)
+		}
+	}
+}
+
+// data returns the encoded surface, with its blocks in twiddled order for PVRTC1
+func (e *pvrtcEncoder) data() []byte {
+	data := make([]byte, e.dataSize())
+	for by := 0; by < e.blocksY; by++ {
+		for bx := 0; bx < e.blocksX; bx++ {
+			block := e.block(bx, by)
+			block.modulation = e.modulationBits(bx, by)
+
+			index := e.blockIndex(bx, by)
+			binary.LittleEndian.PutUint32(data[index*8:], block.modulation)
+			binary.LittleEndian.PutUint32(data[index*8+4:], block.color)
+		}
+	}
+
+	return data
+}
+
+// modulationBits packs the modulation values of the block at bx, by
+func (e *pvrtcEncoder) modulationBits(bx int, by int) uint32 {
+	var bits uint32
+	for py := 0; py < 4; py++ {
+		for px := 0; px < e.blockWidth; px++ {
+			x, y := bx*e.blockWidth+px, by*4+py
+			if x >= e.width || y >= e.height {
+				continue
+			}
+
+			index := uint32(e.indices[y*e.width+x])
+			if e.twoBPP {
+				bits |= index >> 1 << (py*8 + px)
+			} else {
+				bits |= index << (2 * (py*4 + px))
+			}
+		}
+	}
+
+	return bits
+}
+
+// setColors quantizes a and c and stores them as the block's A and B colors, using the standard modulation mode.
+// PVRTC-II blocks are only opaque if both colors are, and leave the hard transition flag in place of A's opacity flag unset
+func (b *pvrtcBlock) setColors(a pvrtcVector, c pvrtcVector, pvrtcII bool) {
+	opaqueA, opaqueB := a[3] > pvrtcOpaqueThreshold, c[3] > pvrtcOpaqueThreshold
+	if !pvrtcII {
+		b.color = quantizeColorB(c, opaqueB)<<16 | quantizeColorA(a, opaqueA)
+		return
+	}
+
+	opaque := opaqueA && opaqueB
+	b.color = quantizeColorB(c, opaque)<<16 | quantizeColorA(a, opaque)&^0x8000
+}
+
+// quantizeColorA returns the 16-bit representation of a PVRTC1 A color, leaving the modulation flag unset
+func quantizeColorA(v pvrtcVector, opaque bool) uint32 {
+	if opaque {
+		return 0x8000 | quantize(v[0], 5)<<10 | quantize(v[1], 5)<<5 | quantize(v[2], 4)<<1
+	}
+
+	return quantizeAlpha(v[3])<<12 | quantize(v[0], 4)<<8 | quantize(v[1], 4)<<4 | quantize(v[2], 3)<<1
+}
+
+// quantizeColorB returns the 16-bit representation of a B color
+func quantizeColorB(v pvrtcVector, opaque bool) uint32 {
+	if opaque {
+		return 0x8000 | quantize(v[0], 5)<<10 | quantize(v[1], 5)<<5 | quantize(v[2], 5)
+	}
+
+	return quantizeAlpha(v[3])<<12 | quantize(v[0], 4)<<8 | quantize(v[1], 4)<<4 | quantize(v[2], 4)
+}
+
+// quantize scales an 8-bit value to the given number of bits
+func quantize(value float64, bits int) uint32 {
+	maxValue := float64(int(1)<<bits - 1)
+	return uint32(math.Round(math.Max(0, math.Min(maxValue, value*maxValue/255))))
+}
+
+// quantizeAlpha returns the 3-bit alpha of a translucent color. See translucentAlpha
+func quantizeAlpha(value float64) uint32 {
+	return uint32(math.Round(math.Max(0, math.Min(7, value/(2*0x11)))))
+}
+
+// colorError returns the squared difference between a and b
+func colorError(a pvrtcColor, b pvrtcColor) int {
+	dr, dg, db, da := a.r-b.r, a.g-b.g, a.b-b.b, a.a-b.a
+	return dr*dr + dg*dg + db*db + da*da
+}
+
+// encodePVRTC compresses img into a PVRTC1 or PVRTC-II surface. PVRTC1 surfaces need power-of-two dimensions.
+// If iterative is set, the block colors are refined in multiple passes, which is slower but looks better
+func encodePVRTC(img *image.NRGBA, twoBPP bool, pvrtcII bool, iterative bool) ([]byte, error) {
+	e, err := newPVRTCEncoder(img, twoBPP, pvrtcII)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := 1
+	if iterative {
+		iterations = pvrtcIterations
+	}
+
+	e.initBlocks()
+	e.chooseModulation()
+	for i := 0; i < iterations; i++ {
+		e.refineBlocks()
+		e.chooseModulation()
+	}
+
+	return e.data(), nil
+}
@@ -0,0 +1,174 @@
+package mtx
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+)
+
+// psnr returns the peak signal-to-noise ratio of b's color channels compared to a's in dB
+func psnr(a *image.NRGBA, b *image.NRGBA) float64 {
+	sum := 0.0
+	for y := 0; y < a.Rect.Dy(); y++ {
+		for x := 0; x < a.Rect.Dx(); x++ {
+			pa, pb := a.NRGBAAt(a.Rect.Min.X+x, a.Rect.Min.Y+y), b.NRGBAAt(b.Rect.Min.X+x, b.Rect.Min.Y+y)
+			for _, d := range []float64{float64(pa.R) - float64(pb.R), float64(pa.G) - float64(pb.G), float64(pa.B) - float64(pb.B)} {
+				sum += d * d
+			}
+		}
+	}
+
+	mse := sum / float64(a.Rect.Dx()*a.Rect.Dy()*3)
+	return 10 * math.Log10(0xFF*0xFF/mse)
+}
+
+// readTestcard decodes examples/testcard.png
+func readTestcard(t *testing.T) *image.NRGBA {
+	f, err := os.Open("../examples/testcard.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return imageToNRGBA(img)
+}
+
+// decodeTestcard decodes the texture of examples/testcard.pvr.mtx
+func decodeTestcard(t *testing.T) *image.NRGBA {
+	f, err := os.Open("../examples/testcard.pvr.mtx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mtxFile, err := Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodePVRImage(mtxFile.PVRHeader, mtxFile.PVRData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestDecodePVRTCMatchesReference(t *testing.T) {
+	want := readTestcard(t)
+	img := decodeTestcard(t)
+	if img.Rect.Size() != want.Rect.Size() {
+		t.Fatalf("got a %v image, want %v", img.Rect.Size(), want.Rect.Size())
+	}
+
+	// the texture stores linear-light colors, so it only matches the reference once it's converted to sRGB
+	linear := psnr(want, img)
+	LinearToSRGB(img)
+	srgb := psnr(want, img)
+	t.Logf("PSNR: %.1f dB in sRGB, %.1f dB in linear light", srgb, linear)
+
+	if srgb < 38 {
+		t.Errorf("got a PSNR of %.1f dB, want at least 38 dB", srgb)
+	}
+	if linear >= srgb {
+		t.Errorf("got a higher PSNR without converting to sRGB (%.1f dB) than with it (%.1f dB)", linear, srgb)
+	}
+}
+
+func TestEncodePVRTCRoundTrip(t *testing.T) {
+	// a power-of-two part of the testcard needs no padding and keeps the test fast
+	testcard := readTestcard(t)
+	want := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	draw.Draw(want, want.Rect, testcard, image.Pt(352, 142), draw.Src)
+
+	// PVRTC-II textures can have any size, including ones that end in partial blocks
+	odd := image.NewNRGBA(image.Rect(0, 0, 258, 130))
+	draw.Draw(odd, odd.Rect, testcard, image.Pt(600, 300), draw.Src)
+
+	tests := []struct {
+		name    string
+		want    *image.NRGBA
+		format  PVRFormat
+		minPSNR float64
+	}{
+		{"pvrtcii4", want, PVRFormatPVRTCII4, 27},
+		{"pvrtcii2", want, PVRFormatPVRTCII2, 25},
+		{"pvrtcii4 with odd sides", odd, PVRFormatPVRTCII4, 25},
+		{"pvrtc4", want, PVRFormatPVRTC4, 27},
+		{"pvrtc2", want, PVRFormatPVRTC2, 25},
+	}
+
+	for _, test := range tests {
+		want := test.want
+		t.Run(test.name, func(t *testing.T) {
+			results := map[bool]float64{}
+			for _, iterative := range []bool{false, true} {
+				header, data, err := EncodePVRImage(want, &BakeOptions{PVRFormat: test.format, PVRTCIterative: iterative})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := DecodePVRImage(header, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				results[iterative] = psnr(want, img)
+				if results[iterative] < test.minPSNR {
+					t.Errorf("iterative %t: got a PSNR of %.1f dB, want at least %.1f dB", iterative, results[iterative], test.minPSNR)
+				}
+			}
+
+			t.Logf("PSNR: %.1f dB fast, %.1f dB iterative", results[false], results[true])
+			if results[true] < results[false] {
+				t.Errorf("got a lower PSNR in iterative mode (%.1f dB) than in fast mode (%.1f dB)", results[true], results[false])
+			}
+		})
+	}
+}
+
+func TestEncodePVRTCKeepsAlpha(t *testing.T) {
+	// the test image fades out towards its right edge, so PVRTC-II blocks need to be translucent
+	// wherever either of their colors is, while PVRTC1 blocks can mix opaque and translucent colors
+	want := testImage()
+
+	for _, format := range []PVRFormat{PVRFormatPVRTCII4, PVRFormatPVRTC4} {
+		header, data, err := EncodePVRImage(want, &BakeOptions{PVRFormat: format})
+		if err != nil {
+			t.Fatal(err)
+		} else if header.PixelFormatFlags&PVR_FLAG_ALPHA == 0 {
+			t.Errorf("%s: the alpha flag isn't set", format)
+		}
+
+		img, err := DecodePVRImage(header, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the texture wraps around, so the opaque left edge bleeds into the transparent right one and vice versa
+		sum := 0
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				diff := int(want.NRGBAAt(x, y).A) - int(img.NRGBAAt(x, y).A)
+				if diff < 0 {
+					diff = -diff
+				}
+				sum += diff
+			}
+		}
+
+		mean := float64(sum) / (64 * 64)
+		t.Logf("%s: alpha differs by %.1f on average", format, mean)
+		if mean > 8 {
+			t.Errorf("%s: got alpha differing by %.1f on average, want at most 8", format, mean)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package mtx
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+/*
+Smaller tiers are resampled with their colors premultiplied by alpha, so invisible pixels don't bleed into visible ones,
+and in floating point, so nothing is rounded until the end. The colors are then divided by the resampled alpha again,
+since MTXv1 files store colors and transparency separately. Resampling can optionally happen in linear light,
+which keeps thin bright details from getting darker when they're blended with their surroundings
+*/
+
+// srgbToLinear maps sRGB channel values to linear light
+var srgbToLinear = func() [256]float32 {
+	var table [256]float32
+	for i := range table {
+		c := float64(i) / 0xFF
+		if c <= 0.04045 {
+			table[i] = float32(c / 12.92)
+		} else {
+			table[i] = float32(math.Pow((c+0.055)/1.055, 2.4))
+		}
+	}
+
+	return table
+}()
+
+// linearToSRGB converts a linear light channel value between 0 and 1 back to sRGB
+func linearToSRGB(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// resampleWeight is the weight of a single source pixel in a resampled one
+type resampleWeight struct {
+	index  int
+	weight float32
+}
+
+// resampleWeights returns the source pixels and weights making up every pixel when resampling
+// srcSize pixels to dstSize ones, like imaging does
+func resampleWeights(dstSize int, srcSize int, filter imaging.ResampleFilter) [][]resampleWeight {
+	scale := float64(srcSize) / float64(dstSize)
+	support := math.Ceil(math.Max(scale, 1) * filter.Support)
+
+	weights := make([][]resampleWeight, dstSize)
+	for v := range weights {
+		center := (float64(v)+0.5)*scale - 0.5
+		begin := int(math.Max(math.Ceil(center-support), 0))
+		end := int(math.Min(math.Floor(center+support), float64(srcSize-1)))
+
+		var raw []float64
+		sum := 0.0
+		for u := begin; u <= end; u++ {
+			if w := filter.Kernel((float64(u) - center) / math.Max(scale, 1)); w != 0 {
+				weights[v] = append(weights[v], resampleWeight{index: u})
+				raw = append(raw, w)
+				sum += w
+			}
+		}
+		for i := range weights[v] {
+			weights[v][i].weight = float32(raw[i] / sum)
+		}
+	}
+
+	return weights
+}
+
+// resizePremultiplied resizes img to width x height with filter, weighting colors by their alpha value.
+// If linear is set, colors are blended in linear light instead of sRGB. Invisible pixels of the result are black,
+// unless filter is imaging.NearestNeighbor, which copies pixels as they are
+func resizePremultiplied(img image.Image, width int, height int, filter imaging.ResampleFilter, linear bool) *image.NRGBA {
+	if filter.Support <= 0 {
+		// nearest-neighbor resampling doesn't blend pixels
+		return imaging.Resize(img, width, height, filter)
+	}
+
+	src := imageToNRGBA(img)
+	srcWidth := src.Rect.Dx()
+	columnWeights := resampleWeights(width, srcWidth, filter)
+	rowWeights := resampleWeights(height, src.Rect.Dy(), filter)
+
+	// rows are resampled horizontally as they're needed and kept in a ring buffer while later rows still use them.
+	// Every resampled row uses a contiguous range of source rows, which only ever moves down
+	ringSize := 1
+	for _, weights := range rowWeights {
+		if len(weights) > 0 {
+			ringSize = atLeast(ringSize, weights[len(weights)-1].index-weights[0].index+1)
+		}
+	}
+	ring := make([]float32, ringSize*width*4)
+	ringRows := make([]int, ringSize)
+	for i := range ringRows {
+		ringRows[i] = -1
+	}
+
+	// srcRow holds the premultiplied channels of a single source row, with values between 0 and 1
+	srcRow := make([]float32, srcWidth*4)
+	horizontalRow := func(y int) []float32 {
+		row := ring[y%ringSize*width*4:][:width*4]
+		if ringRows[y%ringSize] == y {
+			return row
+		}
+		ringRows[y%ringSize] = y
+
+		pix := src.Pix[y*src.Stride:][:srcWidth*4]
+		for i := 0; i < len(pix); i += 4 {
+			a := float32(pix[i+3]) / 0xFF
+			for c := 0; c < 3; c++ {
+				v := float32(pix[i+c]) / 0xFF
+				if linear {
+					v = srgbToLinear[pix[i+c]]
+				}
+				srcRow[i+c] = v * a
+			}
+			srcRow[i+3] = a
+		}
+
+		for x, weights := range columnWeights {
+			var sum [4]float32
+			for _, w := range weights {
+				for c := range sum {
+					sum[c] += srcRow[w.index*4+c] * w.weight
+				}
+			}
+			copy(row[x*4:], sum[:])
+		}
+
+		return row
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	resized := make([]float32, width*4)
+	for y, weights := range rowWeights {
+		for i := range resized {
+			resized[i] = 0
+		}
+		for _, w := range weights {
+			for i, v := range horizontalRow(w.index) {
+				resized[i] += v * w.weight
+			}
+		}
+
+		pix := dst.Pix[y*dst.Stride:][:width*4]
+		for i := 0; i < len(pix); i += 4 {
+			// filters with negative lobes can overshoot
+			a := math.Min(math.Max(float64(resized[i+3]), 0), 1)
+			pix[i+3] = uint8(math.Round(a * 0xFF))
+			if pix[i+3] == 0 {
+				continue
+			}
+
+			for c := 0; c < 3; c++ {
+				v := math.Min(math.Max(float64(resized[i+c])/a, 0), 1)
+				if linear {
+					v = linearToSRGB(v)
+				}
+				pix[i+c] = uint8(math.Round(v * 0xFF))
+			}
+		}
+	}
+
+	return dst
+}
+
+// sharpenColors applies an unsharp mask with the given sigma to img's colors. Its alpha channel is kept as it is
+func sharpenColors(img *image.NRGBA, sigma float64) *image.NRGBA {
+	// fill invisible pixels first so their colors don't darken the edges of visible ones
+	filled := imaging.Clone(img)
+	bleedColors(filled, nil, BleedDilate)
+
+	sharpened := imaging.Sharpen(filled, sigma)
+	for i := 3; i < len(sharpened.Pix); i += 4 {
+		sharpened.Pix[i] = img.Pix[i]
+	}
+
+	return sharpened
+}
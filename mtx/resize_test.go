@@ -0,0 +1,100 @@
+package mtx
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+var update = flag.Bool("update", false, "regenerate the fixtures in testdata")
+
+// pinoutlogoCrop returns the top left 256x256 pixels of examples/pinoutlogo.png,
+// which mix opaque, invisible and partially transparent pixels
+func pinoutlogoCrop(t *testing.T) *image.NRGBA {
+	f, err := os.Open("../examples/pinoutlogo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return imaging.Crop(img, image.Rect(0, 0, 256, 256))
+}
+
+// compareFixture compares img with the fixture at path, or overwrites the fixture with img if -update is set.
+// Channels may differ by 1, since rounding can differ between platforms. Colors of pixels that are invisible
+// in either image aren't compared
+func compareFixture(t *testing.T, path string, img *image.NRGBA) {
+	if *update {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fixture, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := imageToNRGBA(fixture)
+	if img.Rect.Size() != want.Rect.Size() {
+		t.Fatalf("got a %v image, want %v", img.Rect.Size(), want.Rect.Size())
+	}
+
+	differences := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		got, wanted := img.Pix[i:i+4], want.Pix[i:i+4]
+		first := 0
+		if got[3] <= 1 || wanted[3] <= 1 {
+			// only compare the alpha channel
+			first = 3
+		}
+		for c := first; c < 4; c++ {
+			if d := int(got[c]) - int(wanted[c]); d < -1 || d > 1 {
+				differences++
+			}
+		}
+	}
+	if differences > 0 {
+		t.Errorf("%d channels differ from %s. Run the tests with -update if the change is intended", differences, path)
+	}
+}
+
+func TestResizePremultipliedMatchesFixtures(t *testing.T) {
+	tests := []struct {
+		name   string
+		linear bool
+	}{
+		{"pinoutlogo_half.png", false},
+		{"pinoutlogo_half_linear.png", true},
+	}
+
+	img := pinoutlogoCrop(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resized := resizePremultiplied(img, 128, 128, imaging.CatmullRom, test.linear)
+			compareFixture(t, filepath.Join("testdata", test.name), resized)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package mtx
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// Sidecar records the raw contents of an MTX file alongside its extracted images,
+// so the file can be rebuilt byte for byte as long as the images weren't modified
+type Sidecar struct {
+	File     string      `json:"file"` // name of the original MTX file
+	Version  uint32      `json:"version"`
+	Header   *HeaderV0V1 `json:"header,omitempty"`
+	HeaderV2 *HeaderV2   `json:"headerV2,omitempty"`
+
+	Tiers []*SidecarTier `json:"tiers,omitempty"`
+	PVR   *SidecarImage  `json:"pvr,omitempty"`
+
+	Trailing []byte `json:"trailing,omitempty"`
+}
+
+// SidecarImage refers to an extracted image file
+type SidecarImage struct {
+	Name   string `json:"name"`   // file name, relative to the sidecar
+	SHA256 string `json:"sha256"` // hash of the file as it was extracted
+}
+
+// SidecarTier records the raw data of a single quality tier
+type SidecarTier struct {
+	Image SidecarImage `json:"image"`
+
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Color  []byte `json:"color"`
+	Mask   []byte `json:"mask,omitempty"`
+}
+
+// NewSidecar records the raw contents of f. The extracted images' names and hashes are left for the caller to fill in
+func NewSidecar(f *File, name string) *Sidecar {
+	sidecar := &Sidecar{
+		File:     name,
+		Version:  f.Version,
+		Trailing: f.Trailing,
+	}
+
+	switch f.Version {
+	case 0, 1:
+		header := f.Header
+		sidecar.Header = &header
+		for _, tier := range f.Tiers {
+			sidecar.Tiers = append(sidecar.Tiers, &SidecarTier{
+				Width:  tier.Width,
+				Height: tier.Height,
+				Color:  tier.RawColor,
+				Mask:   tier.RawMask,
+			})
+		}
+	case 2:
+		header := f.HeaderV2
+		sidecar.HeaderV2 = &header
+		sidecar.PVR = &SidecarImage{}
+	}
+
+	return sidecar
+}
+
+// Restore rebuilds the MTXv0 or MTXv1 file recorded by the sidecar, with all tiers decoded.
+// limits may be nil
+func (s *Sidecar) Restore(limits *Limits) (*File, error) {
+	if s.Version != 0 && s.Version != 1 {
+		return nil, errors.New("only MTXv0 and MTXv1 files can be restored from a sidecar")
+	}
+
+	rawFile := &File{
+		Version:  s.Version,
+		Trailing: s.Trailing,
+	}
+	if s.Header != nil {
+		// the recorded lengths are written as they are, so files whose header doesn't cover the blocks as expected round-trip
+		rawFile.Header = *s.Header
+	}
+	for _, tier := range s.Tiers {
+		rawFile.Tiers = append(rawFile.Tiers, &Tier{
+			Width:    tier.Width,
+			Height:   tier.Height,
+			RawColor: tier.Color,
+			RawMask:  tier.Mask,
+		})
+	}
+	rawFile.readBlockLengths = rawBlockLengths(s.Version, rawFile.Tiers)
+
+	// round-trip through the encoder so the tiers are decoded and checked the same way as any other file
+	mtxBuf := new(bytes.Buffer)
+	if err := Encode(mtxBuf, rawFile, &BakeOptions{Limits: limits}); err != nil {
+		return nil, err
+	}
+
+	return DecodeWithLimits(mtxBuf, limits)
+}
+
+// UpdateImage replaces the tier's image with img, but keeps the raw color data if img's color channels are unchanged
+// and the raw mask if its alpha channel is unchanged. It reports which parts of the tier will be re-encoded
+func (t *Tier) UpdateImage(img image.Image, withMask bool) (colorChanged bool, maskChanged bool) {
+	bounds := img.Bounds()
+	if bounds.Dx() != t.Width || bounds.Dy() != t.Height || t.Color == nil {
+		t.SetImage(img, withMask)
+		return true, withMask
+	}
+
+	originalColor := imageToNRGBA(t.Color)
+	for y := 0; y < t.Height && !(colorChanged && maskChanged); y++ {
+		for x := 0; x < t.Width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			o := originalColor.NRGBAAt(x, y)
+			if c.R != o.R || c.G != o.G || c.B != o.B {
+				colorChanged = true
+			}
+
+			alpha := uint8(0xFF)
+			if t.Mask != nil {
+				alpha = t.Mask.GrayAt(x, y).Y
+			}
+			if c.A != alpha {
+				maskChanged = true
+			}
+		}
+	}
+
+	if colorChanged {
+		t.Color = img
+		t.RawColor = nil
+		t.PassedThrough = false
+	}
+	if maskChanged && withMask {
+		nrgba := imageToNRGBA(img)
+		t.Mask = newGrayFromRawData(getAlphaChannel(nrgba), t.Width, t.Height)
+		t.RawMask = nil
+	}
+
+	return colorChanged, maskChanged && withMask
+}
@@ -0,0 +1,118 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// blockLengths returns the length of every block of an MTXv1 file
+func blockLengths(f *File) []int64 {
+	var lengths []int64
+	for _, tier := range f.Tiers {
+		lengths = append(lengths, int64(binary.Size(BlockHeaderV1{})+4+len(tier.RawColor)+4+len(tier.RawMask)))
+	}
+
+	return lengths
+}
+
+// oddHeaderFile returns an MTXv1 file with three tiers whose header only covers the first two
+func oddHeaderFile(t *testing.T) []byte {
+	data, err := encodeTestImage(1, testImage(), &BakeOptions{Tiers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lengths := blockLengths(f)
+	binary.LittleEndian.PutUint32(data[4:], uint32(lengths[0]))
+	binary.LittleEndian.PutUint32(data[8:], uint32(lengths[1]))
+	return data
+}
+
+func TestSidecarRestoreKeepsHeader(t *testing.T) {
+	data := oddHeaderFile(t)
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewSidecar(f, "odd.mtx").Restore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, restored, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got header %v, want %v", buf.Bytes()[:12], data[:12])
+	}
+
+	// once a tier changes, the lengths are derived from the blocks again
+	restored.Tiers[0].UpdateImage(image.NewNRGBA(image.Rect(0, 0, restored.Tiers[0].Width, restored.Tiers[0].Height)), true)
+	buf.Reset()
+	if err := Encode(&buf, restored, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := tierRegionLengths(blockLengths(changed)); int64(changed.Header.LengthFirst) != want[0] || int64(changed.Header.LengthSecond) != want[1] {
+		t.Errorf("got lengths %d and %d, want %v", changed.Header.LengthFirst, changed.Header.LengthSecond, want)
+	}
+}
+
+func TestSidecarRestoreEditedJPEG(t *testing.T) {
+	data, err := encodeTestImage(0, testImage(), &BakeOptions{Tiers: 3, JPEGQuality: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewSidecar(f, "edited.mtx").Restore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// repack embeds edited JPEG files as they are, which makes the smallest tier's data longer here
+	tier := restored.Tiers[0]
+	edited := image.NewNRGBA(image.Rect(0, 0, tier.Width, tier.Height))
+	for i := range edited.Pix {
+		edited.Pix[i] = uint8(i * 37)
+	}
+	jpegBuf := bytes.Buffer{}
+	if err := jpeg.Encode(&jpegBuf, edited, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if colorChanged, _ := tier.UpdateImage(edited, false); !colorChanged {
+		t.Fatal("the edited image wasn't detected as changed")
+	}
+	tier.RawColor = jpegBuf.Bytes()
+
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, restored, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	repacked, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(repacked.Tiers) != 3 {
+		t.Fatalf("got %d tiers, want 3", len(repacked.Tiers))
+	} else if !bytes.Equal(repacked.Tiers[0].RawColor, jpegBuf.Bytes()) {
+		t.Error("the edited JPEG data wasn't embedded as it is")
+	}
+}
@@ -0,0 +1,204 @@
+package mtx
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+MTXv2 files always wrap legacy PVR textures. Textures stored in other containers are converted
+to legacy PVR textures when baking and back when extracting, without decoding their pixels.
+*/
+
+// OpenGL identifiers used by KTX files
+const (
+	GL_UNSIGNED_BYTE              = 0x1401
+	GL_UNSIGNED_SHORT_4_4_4_4     = 0x8033
+	GL_UNSIGNED_SHORT_5_5_5_1     = 0x8034
+	GL_UNSIGNED_SHORT_5_6_5       = 0x8363
+	GL_UNSIGNED_SHORT_4_4_4_4_REV = 0x8365
+	GL_UNSIGNED_SHORT_1_5_5_5_REV = 0x8366
+
+	GL_ALPHA           = 0x1906
+	GL_RGB             = 0x1907
+	GL_RGBA            = 0x1908
+	GL_LUMINANCE       = 0x1909
+	GL_LUMINANCE_ALPHA = 0x190A
+	GL_BGR             = 0x80E0
+	GL_BGRA            = 0x80E1
+
+	GL_ALPHA8                           = 0x803C
+	GL_LUMINANCE8                       = 0x8040
+	GL_LUMINANCE8_ALPHA8                = 0x8045
+	GL_RGB8                             = 0x8051
+	GL_RGBA4                            = 0x8056
+	GL_RGB5_A1                          = 0x8057
+	GL_RGBA8                            = 0x8058
+	GL_RGB565                           = 0x8D62
+	GL_ETC1_RGB8_OES                    = 0x8D64
+	GL_COMPRESSED_RGB_PVRTC_4BPPV1_IMG  = 0x8C00
+	GL_COMPRESSED_RGB_PVRTC_2BPPV1_IMG  = 0x8C01
+	GL_COMPRESSED_RGBA_PVRTC_4BPPV1_IMG = 0x8C02
+	GL_COMPRESSED_RGBA_PVRTC_2BPPV1_IMG = 0x8C03
+	GL_COMPRESSED_RGBA_PVRTC_2BPPV2_IMG = 0x9137
+	GL_COMPRESSED_RGBA_PVRTC_4BPPV2_IMG = 0x9138
+)
+
+// Vulkan identifiers used by KTX2 files
+const (
+	VK_FORMAT_R4G4B4A4_UNORM_PACK16     = 2
+	VK_FORMAT_R5G6B5_UNORM_PACK16       = 4
+	VK_FORMAT_R5G5B5A1_UNORM_PACK16     = 6
+	VK_FORMAT_A1R5G5B5_UNORM_PACK16     = 8
+	VK_FORMAT_R8G8B8_UNORM              = 23
+	VK_FORMAT_B8G8R8_UNORM              = 30
+	VK_FORMAT_R8G8B8A8_UNORM            = 37
+	VK_FORMAT_B8G8R8A8_UNORM            = 44
+	VK_FORMAT_ETC2_R8G8B8_UNORM_BLOCK   = 147 // ETC2 decoders can decode ETC1 data
+	VK_FORMAT_PVRTC1_2BPP_UNORM_BLOCK   = 1000054000
+	VK_FORMAT_PVRTC1_4BPP_UNORM_BLOCK   = 1000054001
+	VK_FORMAT_PVRTC2_2BPP_UNORM_BLOCK   = 1000054002
+	VK_FORMAT_PVRTC2_4BPP_UNORM_BLOCK   = 1000054003
+	VK_FORMAT_A4R4G4B4_UNORM_PACK16_EXT = 1000340000
+)
+
+// textureFormat pairs a legacy PVR pixel type with its identifiers in other containers.
+// Zero identifiers mark formats a container can't store
+type textureFormat struct {
+	pixelType uint32
+
+	glType               uint32
+	glTypeSize           uint32
+	glFormat             uint32
+	glInternalFormat     uint32
+	glInternalFormatRGB  uint32 // compressed formats with a separate identifier for textures without alpha
+	glBaseInternalFormat uint32
+
+	vkFormat  uint32
+	ddsFourCC string // compressed formats only. Uncompressed formats are described by their bit masks
+}
+
+// textureFormats lists the pixel types that can be converted to other containers.
+// When converting to legacy PVR textures, the first matching pixel type is used
+var textureFormats = []textureFormat{
+	{PVR_OGL_PVRTC4, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_4BPPV1_IMG, GL_COMPRESSED_RGB_PVRTC_4BPPV1_IMG, GL_RGBA, VK_FORMAT_PVRTC1_4BPP_UNORM_BLOCK, "PTC4"},
+	{PVR_OGL_PVRTC2, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_2BPPV1_IMG, GL_COMPRESSED_RGB_PVRTC_2BPPV1_IMG, GL_RGBA, VK_FORMAT_PVRTC1_2BPP_UNORM_BLOCK, "PTC2"},
+	{PVR_MGL_PVRTC4, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_4BPPV1_IMG, GL_COMPRESSED_RGB_PVRTC_4BPPV1_IMG, GL_RGBA, VK_FORMAT_PVRTC1_4BPP_UNORM_BLOCK, "PTC4"},
+	{PVR_MGL_PVRTC2, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_2BPPV1_IMG, GL_COMPRESSED_RGB_PVRTC_2BPPV1_IMG, GL_RGBA, VK_FORMAT_PVRTC1_2BPP_UNORM_BLOCK, "PTC2"},
+	{PVR_OGL_PVRTCII4, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_4BPPV2_IMG, 0, GL_RGBA, VK_FORMAT_PVRTC2_4BPP_UNORM_BLOCK, ""},
+	{PVR_OGL_PVRTCII2, 0, 1, 0, GL_COMPRESSED_RGBA_PVRTC_2BPPV2_IMG, 0, GL_RGBA, VK_FORMAT_PVRTC2_2BPP_UNORM_BLOCK, ""},
+	{PVR_ETC_RGB_4BPP, 0, 1, 0, GL_ETC1_RGB8_OES, 0, GL_RGB, VK_FORMAT_ETC2_R8G8B8_UNORM_BLOCK, "ETC1"},
+	{PVR_OGL_RGBA_8888, GL_UNSIGNED_BYTE, 1, GL_RGBA, GL_RGBA8, 0, GL_RGBA, VK_FORMAT_R8G8B8A8_UNORM, ""},
+	{PVR_OGL_BGRA_8888, GL_UNSIGNED_BYTE, 1, GL_BGRA, GL_RGBA8, 0, GL_RGBA, VK_FORMAT_B8G8R8A8_UNORM, ""},
+	{PVR_MGL_ARGB_8888, GL_UNSIGNED_BYTE, 1, GL_BGRA, GL_RGBA8, 0, GL_RGBA, VK_FORMAT_B8G8R8A8_UNORM, ""},
+	{PVR_OGL_RGB_888, GL_UNSIGNED_BYTE, 1, GL_RGB, GL_RGB8, 0, GL_RGB, VK_FORMAT_R8G8B8_UNORM, ""},
+	{PVR_MGL_RGB_888, GL_UNSIGNED_BYTE, 1, GL_BGR, GL_RGB8, 0, GL_RGB, VK_FORMAT_B8G8R8_UNORM, ""},
+	{PVR_OGL_RGBA_4444, GL_UNSIGNED_SHORT_4_4_4_4, 2, GL_RGBA, GL_RGBA4, 0, GL_RGBA, VK_FORMAT_R4G4B4A4_UNORM_PACK16, ""},
+	{PVR_MGL_ARGB_4444, GL_UNSIGNED_SHORT_4_4_4_4_REV, 2, GL_BGRA, GL_RGBA4, 0, GL_RGBA, VK_FORMAT_A4R4G4B4_UNORM_PACK16_EXT, ""},
+	{PVR_OGL_RGBA_5551, GL_UNSIGNED_SHORT_5_5_5_1, 2, GL_RGBA, GL_RGB5_A1, 0, GL_RGBA, VK_FORMAT_R5G5B5A1_UNORM_PACK16, ""},
+	{PVR_MGL_ARGB_1555, GL_UNSIGNED_SHORT_1_5_5_5_REV, 2, GL_BGRA, GL_RGB5_A1, 0, GL_RGBA, VK_FORMAT_A1R5G5B5_UNORM_PACK16, ""},
+	{PVR_OGL_RGB_565, GL_UNSIGNED_SHORT_5_6_5, 2, GL_RGB, GL_RGB565, 0, GL_RGB, VK_FORMAT_R5G6B5_UNORM_PACK16, ""},
+	{PVR_MGL_RGB_565, GL_UNSIGNED_SHORT_5_6_5, 2, GL_RGB, GL_RGB565, 0, GL_RGB, VK_FORMAT_R5G6B5_UNORM_PACK16, ""},
+	{PVR_OGL_I_8, GL_UNSIGNED_BYTE, 1, GL_LUMINANCE, GL_LUMINANCE8, 0, GL_LUMINANCE, 0, ""},
+	{PVR_MGL_I_8, GL_UNSIGNED_BYTE, 1, GL_LUMINANCE, GL_LUMINANCE8, 0, GL_LUMINANCE, 0, ""},
+	{PVR_OGL_AI_88, GL_UNSIGNED_BYTE, 1, GL_LUMINANCE_ALPHA, GL_LUMINANCE8_ALPHA8, 0, GL_LUMINANCE_ALPHA, 0, ""},
+	{PVR_MGL_AI_88, GL_UNSIGNED_BYTE, 1, GL_LUMINANCE_ALPHA, GL_LUMINANCE8_ALPHA8, 0, GL_LUMINANCE_ALPHA, 0, ""},
+	{PVR_OGL_A_8, GL_UNSIGNED_BYTE, 1, GL_ALPHA, GL_ALPHA8, 0, GL_ALPHA, 0, ""},
+}
+
+// findTextureFormat returns the entry of textureFormats for the header's pixel type
+func findTextureFormat(h PVRTC2Header) (textureFormat, bool) {
+	for _, f := range textureFormats {
+		if f.pixelType == h.PixelType() {
+			return f, true
+		}
+	}
+
+	return textureFormat{}, false
+}
+
+// isCompressed checks whether the format stores its pixels in blocks
+func (f textureFormat) isCompressed() bool {
+	return f.glType == 0
+}
+
+// newLegacyPVRHeader creates the header of a legacy PVR texture with the given pixel type and layout.
+// The pixel type's bit count and masks, the data size and the mipmap and cubemap flags are filled in.
+// The alpha flag is set for uncompressed formats with an alpha channel, other formats need to set it themselves
+func newLegacyPVRHeader(pixelType uint32, width int, height int, levels int, surfaces int, faces int) (PVRTC2Header, error) {
+	header := PVRTC2Header{
+		HeaderSize:       PVRTC2_HEADER_SIZE,
+		Height:           uint32(height),
+		Width:            uint32(width),
+		MipMapCount:      uint32(atLeast(levels, 1) - 1),
+		PixelFormatFlags: pixelType,
+		Magic:            FourCC{'P', 'V', 'R', '!'},
+		NumSurfaces:      uint32(atLeast(surfaces, 1)),
+	}
+
+	if masks, ok := header.bitMasks(); ok {
+		header.BitCount = uint32(masks.bitCount)
+		header.BitMaskR, header.BitMaskG, header.BitMaskB, header.BitMaskA = masks.r, masks.g, masks.b, masks.a
+		if masks.a != 0 {
+			header.PixelFormatFlags |= PVR_FLAG_ALPHA
+		}
+	} else if pixelType == PVR_OGL_PVRTC2 || pixelType == PVR_MGL_PVRTC2 || pixelType == PVR_OGL_PVRTCII2 {
+		header.BitCount = 2
+	} else {
+		header.BitCount = 4
+	}
+
+	switch faces {
+	case 0, 1:
+	case 6:
+		header.PixelFormatFlags |= PVR_FLAG_CUBEMAP
+		header.NumSurfaces *= 6
+	default:
+		return header, fmt.Errorf("textures with %d faces are unsupported", faces)
+	}
+
+	if header.MipMapCount > 0 {
+		header.PixelFormatFlags |= PVR_FLAG_MIPMAP
+	}
+
+	size, ok := header.ExpectedDataSize()
+	if !ok {
+		return header, fmt.Errorf("the size of %s textures is unknown", header.PixelTypeName())
+	}
+	header.CompressedDataSize = uint32(size)
+
+	return header, nil
+}
+
+// fromLevelMajor converts level-major data, as stored by PVR v3 and KTX files, to the legacy order.
+// It returns ErrTruncated if data doesn't hold every level of every surface
+func fromLevelMajor(h PVRTC2Header, data []byte) ([]byte, error) {
+	levelSizes, surfaces, err := pvr3Layout(h, len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if size, _ := h.ExpectedDataSize(); len(data) < size {
+		return nil, ErrTruncated
+	}
+
+	return reorderSurfaces(data, levelSizes, surfaces, false), nil
+}
+
+// toLevelMajor converts the data of a legacy PVR texture to the level-major order used by PVR v3 and KTX files.
+// Twiddled surfaces are untwiddled, as neither format can store them
+func toLevelMajor(h PVRTC2Header, data []byte) ([]byte, error) {
+	levelSizes, surfaces, err := pvr3Layout(h, len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if size, _ := h.ExpectedDataSize(); len(data) < size {
+		return nil, errors.New("PVR data is too short for the texture's dimensions")
+	}
+	if _, data, err = untwiddle(h, data); err != nil {
+		return nil, err
+	}
+
+	return reorderSurfaces(data, levelSizes, surfaces, true), nil
+}
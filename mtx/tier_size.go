@@ -0,0 +1,114 @@
+package mtx
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OddSizePolicy names a way of sizing smaller tiers when the image's sides can't be halved exactly
+type OddSizePolicy string
+
+const (
+	OddSizeRoundDown OddSizePolicy = "down"   // drop the remainder, like integer division does
+	OddSizeRoundUp   OddSizePolicy = "up"     // round the smaller tiers' sides up
+	OddSizePad       OddSizePolicy = "pad"    // pad the image by repeating its right and bottom edges until its sides halve exactly
+	OddSizeReject    OddSizePolicy = "reject" // refuse images whose sides don't halve exactly
+)
+
+// oddSizePolicies lists all supported OddSizePolicies
+var oddSizePolicies = [...]OddSizePolicy{
+	OddSizeRoundDown,
+	OddSizeRoundUp,
+	OddSizePad,
+	OddSizeReject,
+}
+
+// OddSizePolicies returns all supported OddSizePolicies
+func OddSizePolicies() []OddSizePolicy {
+	return append([]OddSizePolicy(nil), oddSizePolicies[:]...)
+}
+
+// OddSizeUnknown and OddSizeIrregular describe files read from disk whose tier sizes don't tell the policy.
+// Info uses them for files whose sides halve exactly, which every policy sizes alike, and for tiers with unexpected sizes
+const (
+	OddSizeUnknown   OddSizePolicy = "unknown"
+	OddSizeIrregular OddSizePolicy = "irregular"
+)
+
+// maxTierCount returns the number of tiers an image of the given size can be split into
+// before the smaller tiers' sides round down to 0. It's never more than 63, so shifts by one less can't overflow
+func maxTierCount(width int, height int) int {
+	if width > height {
+		width = height
+	}
+
+	return bits.Len(uint(atLeast(width, 0)))
+}
+
+// tierSize returns the size of the tier shift steps below the largest one, which is width x height
+func tierSize(width int, height int, shift int, policy OddSizePolicy) (int, int) {
+	if policy == OddSizeRoundUp {
+		return ceilDiv(width, 1<<shift), ceilDiv(height, 1<<shift)
+	}
+
+	return width >> shift, height >> shift
+}
+
+// fitTiers applies opts.OddSize to img, so that its sides can be halved for every smaller tier.
+// opts.Tiers needs to be between 1 and maxTierCount
+func fitTiers(img image.Image, opts *BakeOptions) (image.Image, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	step := 1 << (opts.Tiers - 1)
+	if width%step == 0 && height%step == 0 {
+		return img, nil
+	}
+
+	switch opts.OddSize {
+	case OddSizePad:
+		paddedWidth, paddedHeight := ceilDiv(width, step)*step, ceilDiv(height, step)*step
+		log.Warnf("Padding the %dx%d image to %dx%d so it can be halved exactly", width, height, paddedWidth, paddedHeight)
+		return padImage(imageToNRGBA(img), paddedWidth, paddedHeight), nil
+	case OddSizeReject:
+		return nil, fmt.Errorf("the image is %dx%d, but its sides need to be multiples of %d to be halved exactly for %d tiers", width, height, step, opts.Tiers)
+	case OddSizeRoundDown, OddSizeRoundUp:
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("unsupported odd size policy %q", opts.OddSize)
+}
+
+// detectOddSize works out which OddSizePolicy the file's smaller tiers were sized with from their dimensions
+func (f *File) detectOddSize() OddSizePolicy {
+	if len(f.Tiers) < 2 {
+		return ""
+	}
+
+	largest := f.Tiers[len(f.Tiers)-1]
+	if len(f.Tiers) > maxTierCount(largest.Width, largest.Height) {
+		return OddSizeIrregular
+	}
+
+	exact, down, up := true, true, true
+	for i, tier := range f.Tiers[:len(f.Tiers)-1] {
+		shift := len(f.Tiers) - 1 - i
+		exact = exact && largest.Width%(1<<shift) == 0 && largest.Height%(1<<shift) == 0
+		width, height := tierSize(largest.Width, largest.Height, shift, OddSizeRoundDown)
+		down = down && tier.Width == width && tier.Height == height
+		width, height = tierSize(largest.Width, largest.Height, shift, OddSizeRoundUp)
+		up = up && tier.Width == width && tier.Height == height
+	}
+
+	switch {
+	case exact && down:
+		return OddSizeUnknown
+	case down:
+		return OddSizeRoundDown
+	case up:
+		return OddSizeRoundUp
+	}
+
+	return OddSizeIrregular
+}
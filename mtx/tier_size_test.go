@@ -0,0 +1,69 @@
+package mtx
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestNewFileRejectsTooManyTiers(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 8))
+	limits := &Limits{MaxTiers: -1}
+	for _, policy := range OddSizePolicies() {
+		for _, tiers := range []int{5, 63, 64, 65, 1000} {
+			if _, err := NewFile(1, img, &BakeOptions{Tiers: tiers, OddSize: policy, Limits: limits}); err == nil {
+				t.Errorf("%d tiers with odd size policy %s: got no error", tiers, policy)
+			}
+		}
+	}
+
+	if _, err := NewFile(1, img, &BakeOptions{Tiers: 4, Limits: limits}); err != nil {
+		t.Errorf("4 tiers: %v", err)
+	}
+}
+
+func TestDetectOddSizeWithManyTiers(t *testing.T) {
+	f := &File{}
+	for i := 0; i < 100; i++ {
+		f.Tiers = append(f.Tiers, &Tier{Width: 1, Height: 1})
+	}
+
+	if got := f.detectOddSize(); got != OddSizeIrregular {
+		t.Errorf("got %q, want %q", got, OddSizeIrregular)
+	}
+}
+
+func TestInfoOddSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		policy        OddSizePolicy
+		want          OddSizePolicy // as told by the tier sizes of the file read from disk
+	}{
+		{64, 32, OddSizeRoundDown, OddSizeUnknown},
+		{63, 33, OddSizeRoundDown, OddSizeRoundDown},
+		{63, 33, OddSizeRoundUp, OddSizeRoundUp},
+		// padded images halve exactly, so nothing tells them apart from even ones
+		{63, 33, OddSizePad, OddSizeUnknown},
+	}
+
+	for _, test := range tests {
+		opts := &BakeOptions{OddSize: test.policy}
+		f, err := NewFile(1, image.NewNRGBA(image.Rect(0, 0, test.width, test.height)), opts)
+		if err != nil {
+			t.Fatal(err)
+		} else if got := f.Info().OddSize; got != test.policy {
+			t.Errorf("%dx%d with %s while baking: got %q, want %q", test.width, test.height, test.policy, got, test.policy)
+		}
+
+		buf := bytes.Buffer{}
+		if err := Encode(&buf, f, opts); err != nil {
+			t.Fatal(err)
+		}
+		f, err = Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if got := f.Info().OddSize; got != test.want {
+			t.Errorf("%dx%d with %s: got %q, want %q", test.width, test.height, test.policy, got, test.want)
+		}
+	}
+}
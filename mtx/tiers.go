@@ -0,0 +1,104 @@
+package mtx
+
+import (
+	"encoding/binary"
+)
+
+/*
+The headers of MTXv0 and MTXv1 files only hold two lengths, which cover the file's tiers like this:
+
+	1 tier:   LengthFirst is 0 and LengthSecond covers the only tier, like some of the games' files do
+	2 tiers:  LengthFirst covers the smaller tier and LengthSecond the larger one
+	N tiers:  LengthFirst covers all tiers but the largest one, ordered from smallest to largest,
+	          and LengthSecond covers the largest one. The games don't use such files
+
+Reading and writing files both go through tierRegionLengths and splitJPEGs, so any tier count round-trips
+*/
+
+// tierRegionLengths returns the header lengths of a file whose tiers' blocks have the given lengths,
+// ordered from smallest to largest
+func tierRegionLengths(blockLengths []int64) [2]int64 {
+	var regions [2]int64
+	for i, length := range blockLengths {
+		if i == len(blockLengths)-1 {
+			regions[1] = length
+		} else {
+			regions[0] += length
+		}
+	}
+
+	return regions
+}
+
+// rawBlockLengths returns the lengths of the blocks the tiers' raw data takes up in an MTX file of the given version
+func rawBlockLengths(version uint32, tiers []*Tier) []int64 {
+	lengths := make([]int64, len(tiers))
+	for i, tier := range tiers {
+		lengths[i] = int64(len(tier.RawColor))
+		if version == 1 {
+			// block header, color data length, mask length and mask data
+			lengths[i] += int64(BLOCK_HEADER_V1_SIZE + 4 + 4 + len(tier.RawMask))
+		}
+	}
+
+	return lengths
+}
+
+// jpegLength returns the length of the JPEG image data starts with, up to and including its EOI marker
+func jpegLength(data []byte) (int, bool) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, false
+	}
+
+	for i := 2; i+2 <= len(data); {
+		if data[i] != 0xFF {
+			return 0, false
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			i++
+			continue
+		case marker == 0xD9:
+			return i + 2, true
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// TEM and RSTn markers have no length
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return 0, false
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+
+		if marker == 0xDA {
+			// skip the entropy-coded data following SOS up to the next marker. 0xFF bytes within it are followed by 0x00 or RSTn
+			for ; i+1 < len(data); i++ {
+				if data[i] == 0xFF && data[i+1] != 0x00 && (data[i+1] < 0xD0 || data[i+1] > 0xD7) {
+					break
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// splitJPEGs splits data into the JPEG images it consists of
+func splitJPEGs(data []byte) ([][]byte, bool) {
+	var images [][]byte
+	for len(data) > 0 {
+		length, ok := jpegLength(data)
+		if !ok {
+			return nil, false
+		}
+
+		images = append(images, data[:length])
+		data = data[length:]
+	}
+
+	return images, true
+}
@@ -0,0 +1,48 @@
+package mtx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTierCountsRoundTrip(t *testing.T) {
+	for version := uint32(0); version <= 1; version++ {
+		for tiers := 1; tiers <= 5; tiers++ {
+			data, err := encodeTestImage(version, testImage(), &BakeOptions{Tiers: tiers})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("MTXv%d with %d tiers: %v", version, tiers, err)
+			} else if len(f.Tiers) != tiers {
+				t.Fatalf("MTXv%d with %d tiers: got %d tiers", version, tiers, len(f.Tiers))
+			}
+
+			// tiers are ordered from smallest to largest, each one half as large as the next
+			for i, tier := range f.Tiers {
+				if size := 64 >> (tiers - 1 - i); tier.Width != size || tier.Height != size {
+					t.Errorf("MTXv%d with %d tiers: tier %d is %dx%d, want %dx%d", version, tiers, i+1, tier.Width, tier.Height, size, size)
+				}
+			}
+
+			if want := tierRegionLengths(rawBlockLengths(version, f.Tiers)); int64(f.Header.LengthFirst) != want[0] || int64(f.Header.LengthSecond) != want[1] {
+				t.Errorf("MTXv%d with %d tiers: got header lengths %d and %d, want %v", version, tiers, f.Header.LengthFirst, f.Header.LengthSecond, want)
+			}
+
+			if problems, err := Validate(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			} else if len(problems) > 0 {
+				t.Errorf("MTXv%d with %d tiers: got problems %v", version, tiers, problems)
+			}
+
+			buf := bytes.Buffer{}
+			if err := Encode(&buf, f, nil); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("MTXv%d with %d tiers: encoding the decoded file changed it", version, tiers)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/jpeg"
+	"io"
+)
+
+// validator walks the structure of an MTX file and collects every problem it finds instead of stopping at the first one
+type validator struct {
+	r        *mtxReader
+	problems []*ParseError
+}
+
+func (v *validator) report(offset int64, field string, err error) {
+	v.problems = append(v.problems, &ParseError{
+		Offset: offset,
+		Block:  v.r.block,
+		Field:  field,
+		Err:    err,
+	})
+}
+
+// reportError records err, which is expected to be a *ParseError returned by the reader
+func (v *validator) reportError(err error) {
+	if parseErr, ok := err.(*ParseError); ok {
+		v.problems = append(v.problems, parseErr)
+	} else {
+		v.report(v.r.offset(), "data", err)
+	}
+}
+
+// checkTrailingData reports any data left after the last block
+func (v *validator) checkTrailingData() {
+	if v.r.Len() > 0 {
+		v.r.block = 0
+		v.report(v.r.offset(), "trailing data", fmt.Errorf("%d unexpected bytes", v.r.Len()))
+	}
+}
+
+// checkJPEG decodes a tier's color data and returns its dimensions
+func (v *validator) checkJPEG(offset int64, data []byte) (int, int, bool) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		v.report(offset, "color data", err)
+		return 0, 0, false
+	} else if err := v.r.limits.CheckImage(config.Width, config.Height); err != nil {
+		v.report(offset, "color data", err)
+		return 0, 0, false
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		v.report(offset, "color data", err)
+		return 0, 0, false
+	}
+
+	return config.Width, config.Height, true
+}
+
+// checkTierSizes makes sure every tier is roughly half as large as the next one
+func (v *validator) checkTierSizes(offsets []int64, sizes [][2]int) {
+	for i := 1; i < len(sizes); i++ {
+		small, large := sizes[i-1], sizes[i]
+		if !isHalfSize(small[0], large[0]) || !isHalfSize(small[1], large[1]) {
+			v.r.block = i
+			v.report(offsets[i-1], "dimensions", fmt.Errorf("%dx%d aren't half of block %d's %dx%d", small[0], small[1], i+1, large[0], large[1]))
+		}
+	}
+}
+
+// isHalfSize checks whether small is large halved, rounded either way
+func isHalfSize(small int, large int) bool {
+	return small == large/2 || small == (large+1)/2
+}
+
+func (v *validator) validateMTXv0() {
+	if err := v.r.need("file header", HEADER_V0V1_SIZE); err != nil {
+		v.reportError(err)
+		return
+	}
+	header, _ := readHeaderV0V1(v.r)
+
+	if total := int64(HEADER_V0V1_SIZE) + int64(header.LengthFirst) + int64(header.LengthSecond); total > v.r.Size() {
+		v.report(4, "header lengths", fmt.Errorf("add up to %d bytes, but the file is only %d bytes long", total, v.r.Size()))
+		return
+	}
+
+	var offsets []int64
+	var sizes [][2]int
+	tiers := 0
+	for i, length := range []uint32{header.LengthFirst, header.LengthSecond} {
+		if length == 0 {
+			continue
+		}
+
+		offset := v.r.offset()
+		region, _ := v.r.readField("color data", int64(length))
+
+		// the first region holds all tiers but the largest one. See tierRegionLengths
+		chunks := [][]byte{region}
+		if i == 0 {
+			if images, ok := splitJPEGs(region); ok {
+				chunks = images
+			}
+		}
+
+		for _, data := range chunks {
+			tiers++
+			v.r.block = tiers
+			if err := v.r.limits.CheckTiers(tiers); err != nil {
+				v.report(offset, "color data", err)
+				break
+			}
+
+			if width, height, ok := v.checkJPEG(offset, data); ok {
+				offsets = append(offsets, offset)
+				sizes = append(sizes, [2]int{width, height})
+			}
+			offset += int64(len(data))
+		}
+	}
+
+	v.checkTierSizes(offsets, sizes)
+	v.checkTrailingData()
+}
+
+func (v *validator) validateMTXv1() {
+	if err := v.r.need("file header", HEADER_V0V1_SIZE); err != nil {
+		v.reportError(err)
+		return
+	}
+	header, _ := readHeaderV0V1(v.r)
+
+	var offsets []int64
+	var sizes [][2]int
+	var blockLengths []int64
+	headerEnd := int64(HEADER_V0V1_SIZE) + int64(header.LengthFirst) + int64(header.LengthSecond)
+	complete := true
+
+	for v.r.block = 1; v.r.Len() > 0; v.r.block++ {
+		blockOffset := v.r.offset()
+		if err := v.r.limits.CheckTiers(v.r.block); err != nil {
+			v.report(blockOffset, "block", err)
+			complete = false
+			break
+		} else if blockOffset >= headerEnd {
+			v.report(blockOffset, "block", fmt.Errorf("is unexpected, it lies beyond the lengths in the file header"))
+		}
+
+		if err := v.r.need("header", BLOCK_HEADER_V1_SIZE); err != nil {
+			v.reportError(err)
+			complete = false
+			break
+		}
+		blockHeader, _ := readBlockHeaderV1(v.r)
+		if blockHeader.Magic != 1 {
+			v.report(blockOffset, "header magic", fmt.Errorf("is %d instead of 1", blockHeader.Magic))
+		}
+
+		colorOffset := v.r.offset() + 4
+		colorData, err := v.r.readChunk("color")
+		if err != nil {
+			v.reportError(err)
+			complete = false
+			break
+		}
+
+		if width, height, ok := v.checkJPEG(colorOffset, colorData); ok {
+			if width != int(blockHeader.Width) || height != int(blockHeader.Height) {
+				v.report(blockOffset, fmt.Sprintf("header dimensions %dx%d", blockHeader.Width, blockHeader.Height), fmt.Errorf("don't match the image dimensions %dx%d", width, height))
+			}
+		}
+
+		offsets = append(offsets, blockOffset)
+		sizes = append(sizes, [2]int{int(blockHeader.Width), int(blockHeader.Height)})
+
+		maskOffset := v.r.offset() + 4
+		maskData, err := v.r.readChunk("mask")
+		if err != nil {
+			v.reportError(err)
+			complete = false
+			break
+		}
+
+		if mask, err := decompressZlibData(maskData, v.r.limits.MaxMaskBytes); err != nil {
+			v.report(maskOffset, "mask data", err)
+		} else if expected := int(blockHeader.Width) * int(blockHeader.Height); len(mask) != expected {
+			v.report(maskOffset, fmt.Sprintf("mask data length %d", len(mask)), fmt.Errorf("doesn't match the block dimensions, expected %d", expected))
+		}
+
+		if blockOffset < headerEnd {
+			blockLengths = append(blockLengths, v.r.offset()-blockOffset)
+		}
+	}
+
+	// the header's length fields cover the blocks of all smaller tiers and the block of the largest one. See tierRegionLengths
+	v.r.block = 0
+	expectedBlocks := 2
+	if header.LengthFirst == 0 {
+		expectedBlocks = 1
+	}
+	if len(blockLengths) < expectedBlocks {
+		v.report(0, "file", fmt.Errorf("contains %d blocks, expected at least %d", len(blockLengths), expectedBlocks))
+	} else if complete {
+		regionLengths := tierRegionLengths(blockLengths)
+		for i, length := range []uint32{header.LengthFirst, header.LengthSecond} {
+			if int64(length) != regionLengths[i] {
+				v.report(int64(4+i*4), "header length", fmt.Errorf("is %d, but the blocks it covers are %d bytes long", length, regionLengths[i]))
+			}
+		}
+	}
+
+	v.checkTierSizes(offsets, sizes)
+}
+
+func (v *validator) validateMTXv2() {
+	if err := v.r.need("file header", HEADER_V2_SIZE); err != nil {
+		v.reportError(err)
+		return
+	}
+	_, _ = readHeaderV2(v.r)
+
+	headerOffset := v.r.offset()
+	pvrHeader, _, err := decodePVR(v.r)
+	if err != nil {
+		v.reportError(err)
+		return
+	}
+
+	if expected, ok := pvrHeader.ExpectedDataSize(); !ok {
+		v.report(headerOffset+16, "PVR pixel type", fmt.Errorf("%s is unknown, so the data size can't be checked", pvrHeader.PixelTypeName()))
+	} else if int(pvrHeader.CompressedDataSize) != expected {
+		v.report(headerOffset+20, fmt.Sprintf("PVR data size %d", pvrHeader.CompressedDataSize), fmt.Errorf("doesn't match the %dx%d %s texture with %d mip level(s) and %d surface(s), expected %d", pvrHeader.Width, pvrHeader.Height, pvrHeader.PixelTypeName(), pvrHeader.MipMapCount+1, atLeast(int(pvrHeader.NumSurfaces), 1), expected))
+	}
+
+	v.checkTrailingData()
+}
+
+// Validate checks the structure of the MTX file read from r using the default limits and returns every problem found.
+// The returned error is only non-nil if r couldn't be read
+func Validate(r io.Reader) ([]*ParseError, error) {
+	return ValidateWithLimits(r, nil)
+}
+
+// ValidateWithLimits checks the MTX file read from r like Validate, but also reports anything exceeding limits, which may be nil
+func ValidateWithLimits(r io.Reader, limits *Limits) ([]*ParseError, error) {
+	data, err := readInput(r, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{r: newMTXReader(data, limits)}
+	if len(data) < 4 {
+		v.report(0, "file header", ErrTruncated)
+		return v.problems, nil
+	}
+
+	switch fileVersion := binary.LittleEndian.Uint32(data); fileVersion {
+	case 0:
+		v.validateMTXv0()
+	case 1:
+		v.validateMTXv1()
+	case 2:
+		v.validateMTXv2()
+	default:
+		v.report(0, fmt.Sprintf("MTX version 0x%X", fileVersion), ErrUnsupportedVersion)
+	}
+
+	return v.problems, nil
+}
@@ -0,0 +1,76 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+)
+
+// corruptedFile encodes the test image as an MTX file of the given version after corrupt has modified it
+func corruptedFile(t *testing.T, version uint32, corrupt func(f *File)) []byte {
+	f, err := NewFile(version, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(f)
+
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+		want []string // beginnings of the fields every problem is expected to be reported for, in order
+	}{
+		{"mask length and header length", func(t *testing.T) []byte {
+			data := corruptedFile(t, 1, func(f *File) {
+				f.Tiers[0].RawMask, _ = compressZlibData(make([]byte, 10), 9)
+			})
+			binary.LittleEndian.PutUint32(data[4:], binary.LittleEndian.Uint32(data[4:])+1)
+			return data
+		}, []string{"mask data length 10", "header length"}},
+		{"tier sizes and trailing data", func(t *testing.T) []byte {
+			return corruptedFile(t, 0, func(f *File) {
+				f.Tiers[0].SetImage(image.NewNRGBA(image.Rect(0, 0, 10, 10)), false)
+				f.Trailing = []byte{1, 2, 3}
+			})
+		}, []string{"dimensions", "trailing data"}},
+		{"PVR data size and trailing data", func(t *testing.T) []byte {
+			data := mtxv2File(0, 1)
+			binary.LittleEndian.PutUint32(data[HEADER_V2_SIZE+20:], 40)
+			return append(data, make([]byte, 8+3)...)
+		}, []string{"PVR data size 40", "trailing data"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, err := Validate(bytes.NewReader(test.data(t)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fields []string
+			for _, problem := range problems {
+				fields = append(fields, problem.Field)
+			}
+
+			if len(fields) != len(test.want) {
+				t.Fatalf("got problems with %q, want %q", fields, test.want)
+			}
+			for i, field := range fields {
+				if !strings.HasPrefix(field, test.want[i]) {
+					t.Errorf("got problems with %q, want %q", fields, test.want)
+					break
+				}
+			}
+		})
+	}
+}